@@ -1,6 +1,18 @@
 package stash
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 func CreateCacheItem(key interface{}, item Cacheable) (*CachedItem, error) {
 	bytes, err := item.MarshalBinary()
@@ -29,3 +41,235 @@ func UpdateCacheItem(cacheItem *CachedItem, item Cacheable) error {
 	cacheItem.Size = len(bytes)
 	return nil
 }
+
+// NewStatus will create the status of a ping that started at the
+// given time and returned the given error
+func NewStatus(tStart time.Time, err error) Status {
+	return Status{
+		LastPing:  tStart,
+		LastError: err,
+		Latency:   time.Since(tStart),
+	}
+}
+
+// ParseDuration will parse a duration provided as a number of seconds
+// (e.g. 30) or as a duration string (e.g. 30s or 5m)
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Environ returns the environmental variables of the process, if a
+// prefix is provided, only the variables with the prefix are returned
+// (with the prefix removed)
+func Environ(prefix string) map[string]string {
+	envs := make(map[string]string)
+	for _, env := range os.Environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		envs[strings.TrimPrefix(key, prefix)] = value
+	}
+	return envs
+}
+
+// UnmarshalConfiguration will unmarshal the given json into the given
+// configuration (a pointer to a struct), fields that aren't present keep
+// their values and unknown fields are rejected; durations can be provided
+// as strings (e.g. "30s") or as a number of nanoseconds. Each field is
+// unmarshalled on its own so the error describes every invalid field
+func UnmarshalConfiguration(data []byte, config any) error {
+	var raw map[string]json.RawMessage
+	var errs ConfigurationError
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(config).Elem()
+	fields := make(map[string]reflect.Value, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		if name := fieldName(v.Type().Field(i)); name != "" && v.Field(i).CanSet() {
+			fields[name] = v.Field(i)
+		}
+	}
+	for name, value := range raw {
+		field, ok := fields[name]
+		if !ok {
+			errs.Add(name, errors.New("unknown field"))
+			continue
+		}
+		if err := unmarshalField(value, field); err != nil {
+			errs.Add(name, err)
+		}
+	}
+	return errs.Err()
+}
+
+// RegisterFlags will register a flag for each field of the given
+// configuration (a pointer to a struct) onto the given flag set, the
+// name of the flag is the json tag of the field (e.g. max_size is
+// registered as max-size) prefixed with the given prefix and its usage
+// is the usage tag of the field; setting a flag sets the field, slices
+// are provided as comma separated values and durations as seconds
+// (e.g. 30) or duration strings (e.g. 30s)
+func RegisterFlags(fs *flag.FlagSet, prefix string, config any) {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := fieldName(v.Type().Field(i))
+		if name == "" || !settable(v.Field(i)) {
+			continue
+		}
+		usage := v.Type().Field(i).Tag.Get("usage")
+		if usage == "" {
+			usage = strings.ReplaceAll(name, "_", " ")
+		}
+		fs.Var(&configurationFlag{config: config, field: v.Field(i)},
+			prefix+strings.ReplaceAll(name, "_", "-"), usage)
+	}
+}
+
+// ApplyFlags will set the fields of the given configuration using the
+// flags (registered using RegisterFlags) that have been set, it can be
+// used to apply the flags after the configuration has been loaded from
+// other sources
+func ApplyFlags(fs *flag.FlagSet, config any) {
+	fs.Visit(func(f *flag.Flag) {
+		if c, ok := f.Value.(*configurationFlag); ok && c.config == config && c.set {
+			_ = setField(c.field, c.value)
+		}
+	})
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configurationFlag is a flag bound to a field of a configuration, the
+// value it was set to is kept so that it can be applied again
+type configurationFlag struct {
+	config any
+	field  reflect.Value
+	value  string
+	set    bool
+}
+
+func (c *configurationFlag) String() string {
+	if c == nil || !c.field.IsValid() {
+		return ""
+	}
+	switch value := c.field.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case []string:
+		return strings.Join(value, ",")
+	}
+	return fmt.Sprint(c.field.Interface())
+}
+
+func (c *configurationFlag) Set(value string) error {
+	if err := setField(c.field, value); err != nil {
+		return err
+	}
+	c.value, c.set = value, true
+	return nil
+}
+
+// IsBoolFlag allows boolean flags to be set without a value
+// (e.g. -debug)
+func (c *configurationFlag) IsBoolFlag() bool {
+	return c.field.IsValid() && c.field.Kind() == reflect.Bool
+}
+
+// fieldName returns the name of the json tag of the field
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// unmarshalField will unmarshal the given json into the field, the field
+// is only set if the json can be unmarshalled
+func unmarshalField(data json.RawMessage, field reflect.Value) error {
+	if field.Type() == durationType && bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		duration, err := ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	value := reflect.New(field.Type())
+	value.Elem().Set(field)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value.Interface()); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) {
+			return errors.Errorf("cannot unmarshal %s into %s", typeError.Value, typeError.Type)
+		}
+		return err
+	}
+	field.Set(value.Elem())
+	return nil
+}
+
+// settable returns true if the field can be set from a string
+func settable(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return field.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setField will parse the given value and set the field
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		duration, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	switch field.Kind() {
+	default:
+		return errors.Errorf("unsupported type: %s", field.Type())
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		if value != "" {
+			values = strings.Split(value, ",")
+		}
+		field.Set(reflect.ValueOf(values).Convert(field.Type()))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package file
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/antonio-alexander/go-stash"
+)
+
+const (
+	defaultDirectory      string               = "./tmp/go-stash"
+	defaultEvictionPolicy stash.EvictionPolicy = stash.LeastFrequentlyUsed
+	defaultTimeToLive     time.Duration        = 30 * time.Second
+	defaultMaxSize        int                  = 10 * 1024 * 1024
+	defaultDebugEnabled   bool                 = true
+)
+
+// Configuration describes what can be configured for the
+// file stash
+type Configuration struct {
+	Directory      string               `json:"directory"`
+	EvictionPolicy stash.EvictionPolicy `json:"eviction_policy"`
+	TimeToLive     time.Duration        `json:"time_to_live"`
+	MaxSize        int                  `json:"max_size"`
+	Debug          bool                 `json:"debug"`
+	DebugPrefix    string               `json:"debug_prefix"`
+}
+
+func NewConfiguration() *Configuration {
+	return &Configuration{
+		Directory:      defaultDirectory,
+		EvictionPolicy: defaultEvictionPolicy,
+		TimeToLive:     defaultTimeToLive,
+		MaxSize:        defaultMaxSize,
+		Debug:          defaultDebugEnabled,
+	}
+}
+
+// FromEnvs will set the configuration using the given environmental
+// variables, an error is returned for each value that can't be parsed;
+// durations can be provided as seconds (e.g. 30) or as a duration
+// string (e.g. 30s)
+func (c *Configuration) FromEnvs(envs map[string]string) error {
+	var errs stash.ConfigurationError
+
+	for key, value := range envs {
+		var err error
+
+		if value == "" {
+			continue
+		}
+		switch key {
+		case "FILE_DIRECTORY":
+			c.Directory = value
+		case "STASH_EVICTION_POLICY":
+			c.EvictionPolicy = stash.EvictionPolicy(value)
+		case "STASH_TIME_TO_LIVE":
+			c.TimeToLive, err = stash.ParseDuration(value)
+		case "STASH_MAX_SIZE":
+			c.MaxSize, err = strconv.Atoi(value)
+		case "STASH_DEBUG_ENABLED":
+			c.Debug, err = strconv.ParseBool(value)
+		case "STASH_DEBUG_PREFIX":
+			c.DebugPrefix = value
+		}
+		if err != nil {
+			errs.Add(key, err)
+		}
+	}
+	return errs.Err()
+}
+
+func (c *Configuration) Default() {
+	if c == nil {
+		return
+	}
+
+	c.Directory = defaultDirectory
+	c.EvictionPolicy = defaultEvictionPolicy
+	c.TimeToLive = defaultTimeToLive
+	c.MaxSize = defaultMaxSize
+	c.Debug = defaultDebugEnabled
+}
@@ -0,0 +1,132 @@
+package file
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/antonio-alexander/go-stash"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	shardWidth    int    = 2
+	fileExtension string = ".stash"
+	tempPattern   string = ".tmp-*"
+)
+
+func parseKey(key any) (string, error) {
+	switch key := key.(type) {
+	default:
+		return "", errors.Errorf("unsupported key type: %T", key)
+	case string:
+		return key, nil
+	}
+}
+
+// keyToPath will hash the key and return the shard directory as well
+// as the full path of the file used to store the item, this spreads the
+// files across 256 directories so no single directory grows too large
+func keyToPath(directory, key string) (string, string) {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	shard := filepath.Join(directory, name[:shardWidth])
+	return shard, filepath.Join(shard, name+fileExtension)
+}
+
+// writeItem will atomically write the cached item to disk, the item is
+// written to a temporary file within the same directory and then renamed
+// so that readers will only ever see a complete file
+// KIM: the file contains a single line of json describing the item (without
+// its bytes) followed by the raw bytes, this allows the index to be rebuilt
+// without reading the value of every item
+func writeItem(directory string, cachedItem *stash.CachedItem) error {
+	key, err := parseKey(cachedItem.Key)
+	if err != nil {
+		return err
+	}
+	shard, path := keyToPath(directory, key)
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return err
+	}
+	header := *cachedItem
+	header.Bytes = nil
+	bytes, err := json.Marshal(&header)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(shard, tempPattern)
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+	if err := func() error {
+		defer file.Close()
+		if _, err := file.Write(append(bytes, '\n')); err != nil {
+			return err
+		}
+		if _, err := file.Write(cachedItem.Bytes); err != nil {
+			return err
+		}
+		return file.Sync()
+	}(); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// readItem will read the cached item from the file at the given path,
+// if readBytes is false, only the header will be read
+func readItem(path string, readBytes bool) (*stash.CachedItem, error) {
+	var cachedItem stash.CachedItem
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	header, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read header for %s", path)
+	}
+	if err := json.Unmarshal(header, &cachedItem); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode header for %s", path)
+	}
+	if !readBytes {
+		return &cachedItem, nil
+	}
+	bytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	cachedItem.Bytes = bytes
+	return &cachedItem, nil
+}
+
+func toSlice(items map[string]*stash.CachedItem) []*stash.CachedItem {
+	cachedItems := make([]*stash.CachedItem, 0, len(items))
+	for _, cachedItem := range items {
+		cachedItems = append(cachedItems, cachedItem)
+	}
+	return cachedItems
+}
+
+// checkDirectory will return an error if the given directory can't be
+// accessed or isn't a directory
+func checkDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("not a directory: %s", directory)
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package file
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-stash"
+
+	"github.com/pkg/errors"
+)
+
+type stashFile struct {
+	sync.Mutex
+	logger      stash.Logger
+	index       map[string]*stash.CachedItem
+	status      stash.Status
+	statusLock  sync.Mutex
+	config      *Configuration
+	size        int
+	initialized bool
+	configured  bool
+}
+
+// New can be used to create a concrete instance of a file
+// cache/stash; each item is stored in its own file within
+// the configured directory
+func New(parameters ...any) interface {
+	stash.Stasher
+	stash.Configurer
+	stash.Initializer
+	stash.Shutdowner
+	stash.Parameterizer
+	stash.HealthChecker
+} {
+	s := &stashFile{
+		index: make(map[string]*stash.CachedItem),
+	}
+	s.SetParameters(parameters...)
+	return s
+}
+
+func (s *stashFile) printf(format string, a ...any) {
+	if s.logger != nil && s.config != nil && s.config.Debug {
+		s.logger.Printf(s.config.DebugPrefix+format, a...)
+	}
+}
+
+func (s *stashFile) remove(key string) error {
+	cachedItem, found := s.index[key]
+	if !found {
+		return nil
+	}
+	_, path := keyToPath(s.config.Directory, key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.index, key)
+	s.size -= cachedItem.Size
+	return nil
+}
+
+// evict will remove any items whose time to live has been exceeded
+// and then (using the eviction policy) remove items until the size of
+// the stash is below the max size; the exclude key is never evicted so
+// that the item most recently accessed is always available
+func (s *stashFile) evict(exclude string) {
+	if s.config.TimeToLive > 0 {
+		tNow := time.Now()
+		for key, cachedItem := range s.index {
+			if key == exclude || tNow.Sub(time.Unix(0, cachedItem.LastUpdated)) <= s.config.TimeToLive {
+				continue
+			}
+			if err := s.remove(key); err != nil {
+				s.printf("error while evicting: %s\n", err.Error())
+				continue
+			}
+			s.printf("evicted key: %v, ttl exceeded\n", key)
+		}
+	}
+	if s.config.MaxSize <= 0 || s.size <= s.config.MaxSize {
+		return
+	}
+	cachedItems := toSlice(s.index)
+	switch s.config.EvictionPolicy {
+	default:
+		sort.Sort(stash.ByFirstCreated(cachedItems))
+	case stash.LeastRecentlyUsed:
+		sort.Sort(stash.ByLastRead(cachedItems))
+	case stash.LeastFrequentlyUsed:
+		sort.Sort(stash.ByTimesRead(cachedItems))
+	}
+	for _, cachedItem := range cachedItems {
+		//ensure we don't evict the only data that's in the
+		// stash even if we're above the max limit
+		if s.size <= s.config.MaxSize || len(s.index) <= 1 {
+			return
+		}
+		key, _ := parseKey(cachedItem.Key)
+		if key == exclude {
+			continue
+		}
+		if err := s.remove(key); err != nil {
+			s.printf("error while evicting: %s\n", err.Error())
+			continue
+		}
+		s.printf("evicted key: %v, max size exceeded\n", key)
+	}
+}
+
+// load will walk the configured directory and rebuild the index
+// from the headers of the files found; temporary files left over
+// from an interrupted write are removed
+func (s *stashFile) load() error {
+	return filepath.WalkDir(s.config.Directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch {
+		case strings.HasPrefix(d.Name(), strings.TrimSuffix(tempPattern, "*")):
+			if err := os.Remove(path); err != nil {
+				s.printf("error while removing temporary file %s: %s\n", path, err)
+			}
+		case strings.HasSuffix(d.Name(), fileExtension):
+			cachedItem, err := readItem(path, false)
+			if err != nil {
+				s.printf("removing unreadable file %s: %s\n", path, err)
+				return os.Remove(path)
+			}
+			key, err := parseKey(cachedItem.Key)
+			if err != nil {
+				s.printf("removing file %s: %s\n", path, err)
+				return os.Remove(path)
+			}
+			s.index[key] = cachedItem
+			s.size += cachedItem.Size
+		}
+		return nil
+	})
+}
+
+// Configure
+func (s *stashFile) Configure(items ...any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var config *Configuration
+
+	for _, item := range items {
+		switch item := item.(type) {
+		case *Configuration:
+			config = item
+		case Configuration:
+			config = &item
+		case map[string]string:
+			config = &Configuration{}
+			config.Default()
+			if err := config.FromEnvs(item); err != nil {
+				return err
+			}
+		}
+	}
+	if config != nil {
+		s.config = config
+		s.configured = true
+	}
+
+	return nil
+}
+
+// SetParameters
+func (s *stashFile) SetParameters(items ...any) {
+	for _, item := range items {
+		switch item := item.(type) {
+		case stash.Logger:
+			s.logger = item
+		}
+	}
+}
+
+// Initialize can be used to setup internal pointers
+// and ready the stash for usage; any items previously
+// written to the configured directory will be loaded
+func (s *stashFile) Initialize() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.configured {
+		return errors.New("not configured")
+	}
+	if s.initialized {
+		return errors.New("already initialized")
+	}
+	if s.config.Directory == "" {
+		return errors.New("directory not configured")
+	}
+	if err := os.MkdirAll(s.config.Directory, 0755); err != nil {
+		return err
+	}
+	s.size = 0
+	s.index = make(map[string]*stash.CachedItem)
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.config.MaxSize > 0 {
+		maxSize := float64(s.config.MaxSize) / 1024 / 1024
+		s.printf("configured max size: %fMB\n", maxSize)
+	}
+	if s.config.TimeToLive > 0 {
+		s.printf("configured time to live: %v\n", s.config.TimeToLive)
+	}
+	s.printf("configured eviction policy: %s\n", s.config.EvictionPolicy)
+	s.printf("loaded %d items from %s\n", len(s.index), s.config.Directory)
+	s.evict("")
+	s.initialized = true
+
+	return nil
+}
+
+// Shutdown can be used to tear down internal pointers
+// and ready the stash for garbage collection (or reuse)
+// KIM: items are left on disk so they can be loaded by
+// a subsequent call to Initialize
+func (s *stashFile) Shutdown() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return nil
+	}
+	s.size = 0
+	s.index = make(map[string]*stash.CachedItem)
+	s.initialized, s.configured = false, false
+
+	return nil
+}
+
+// Ping can be used to check whether the stash is usable, an
+// error is returned if the stash hasn't been initialized or
+// its directory can't be accessed
+func (s *stashFile) Ping(ctx context.Context) error {
+	tStart := time.Now()
+	err := ctx.Err()
+	if err == nil {
+		s.Lock()
+		switch {
+		default:
+			err = checkDirectory(s.config.Directory)
+		case !s.initialized:
+			err = errors.New("not initialized")
+		}
+		s.Unlock()
+	}
+	s.statusLock.Lock()
+	s.status = stash.NewStatus(tStart, err)
+	s.statusLock.Unlock()
+	return err
+}
+
+// Status returns the result of the last ping
+func (s *stashFile) Status() stash.Status {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	return s.status
+}
+
+// Write can be used to create/update a value in the cache with the given
+// key. If the value exists, replaced will be true
+func (s *stashFile) Write(key any, item stash.Cacheable) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return false, errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return false, err
+	}
+	var cachedItem *stash.CachedItem
+	existingItem, found := s.index[field]
+	if found {
+		updatedItem := *existingItem
+		if err := stash.UpdateCacheItem(&updatedItem, item); err != nil {
+			return false, err
+		}
+		cachedItem = &updatedItem
+	} else {
+		cachedItem, err = stash.CreateCacheItem(field, item)
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := writeItem(s.config.Directory, cachedItem); err != nil {
+		return false, err
+	}
+	if found {
+		s.size -= existingItem.Size
+	}
+	cachedItem.Bytes = nil
+	s.index[field] = cachedItem
+	s.size += cachedItem.Size
+	if found {
+		s.printf("updated key: %v\n", key)
+	} else {
+		s.printf("created key: %v\n", key)
+	}
+	s.evict(field)
+
+	return found, nil
+}
+
+// Read can be used to read a value in the cache with the given key
+// if the value exists, it will be unmarshalled into the Cacheable
+// pointer; this is expected to work very much like an Unmarshal
+// function. If a value isn't found with the given key, an error
+// will be returned
+// KIM: the read statistics are only kept in the index, they're
+// persisted the next time the item is written
+func (s *stashFile) Read(key any, v stash.Cacheable) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	indexedItem, found := s.index[field]
+	if !found {
+		return errors.Errorf("value for %v not found", key)
+	}
+	_, path := keyToPath(s.config.Directory, field)
+	cachedItem, err := readItem(path, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(s.index, field)
+			s.size -= indexedItem.Size
+			return errors.Errorf("value for %v not found", key)
+		}
+		return err
+	}
+	indexedItem.LastRead = time.Now().UnixNano()
+	indexedItem.NTimesRead++
+	if err := v.UnmarshalBinary(cachedItem.Bytes); err != nil {
+		return err
+	}
+	s.printf("read key: %v\n", key)
+	s.evict(field)
+
+	return nil
+}
+
+// Delete can be used to remove a value from the cache with a given
+// key. If the value isn't found, an error is returned.
+func (s *stashFile) Delete(key any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.index[field]; !ok {
+		return errors.Errorf("value not found for key: %v", key)
+	}
+	if err := s.remove(field); err != nil {
+		return err
+	}
+	s.printf("deleted key: %v\n", key)
+	s.evict("")
+
+	return nil
+}
+
+func (s *stashFile) Clear() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	for key := range s.index {
+		if err := s.remove(key); err != nil {
+			return err
+		}
+	}
+	s.size = 0
+	s.index = make(map[string]*stash.CachedItem)
+	s.printf("cleared cache\n")
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package file_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antonio-alexander/go-stash"
+	"github.com/antonio-alexander/go-stash/file"
+	"github.com/antonio-alexander/go-stash/internal"
+	"github.com/antonio-alexander/go-stash/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStashFile(t *testing.T) {
+	const debug = true
+
+	newStash := func(config file.Configuration) interface {
+		stash.Stasher
+		stash.Shutdowner
+	} {
+		logger := internal.NewLogger()
+		f := file.New()
+		f.SetParameters(logger)
+		err := f.Configure(config)
+		assert.Nil(t, err)
+		err = f.Initialize()
+		assert.Nil(t, err)
+		return f
+	}
+	t.Run("Stash", tests.TestStash(t, func() stash.Stasher {
+		return newStash(file.Configuration{Directory: t.TempDir()})
+	}))
+	t.Run("Evict Size", tests.TestEvictSize(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(file.Configuration{
+				Directory:   t.TempDir(),
+				TimeToLive:  timeToLive,
+				MaxSize:     maxSize,
+				Debug:       debug,
+				DebugPrefix: "[stash] ",
+			})
+		}))
+	t.Run("Evict Least Recently Used", tests.TestEvictLeastRecentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(file.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.LeastRecentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Least Frequently Used", tests.TestEvictLeastFrequentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(file.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.LeastFrequentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict First In First Out", tests.TestEvictFirstInFirstOut(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(file.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.FirstInFirstOut,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Persist", func(t *testing.T) {
+		config := file.Configuration{Directory: t.TempDir()}
+		key := "persist"
+		example := &stash.Example{Int: 1, String: "persist"}
+
+		//write the example and shutdown
+		s := newStash(config)
+		replaced, err := s.Write(key, example)
+		assert.Nil(t, err)
+		assert.False(t, replaced)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//validate the example can be read once loaded
+		s = newStash(config)
+		exampleRead := &stash.Example{}
+		err = s.Read(key, exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, example, exampleRead)
+		replaced, err = s.Write(key, example)
+		assert.Nil(t, err)
+		assert.True(t, replaced)
+	})
+	t.Run("Health Check", func(t *testing.T) {
+		s := newStash(file.Configuration{Directory: t.TempDir()})
+		tests.TestHealthCheck(t, func() stash.HealthChecker {
+			return s.(stash.HealthChecker)
+		}, func() stash.HealthChecker {
+			return file.New()
+		})(t)
+		err := s.(stash.Shutdowner).Shutdown()
+		assert.Nil(t, err)
+		err = s.(stash.Pinger).Ping(context.Background())
+		assert.NotNil(t, err)
+		assert.False(t, s.(stash.HealthChecker).Status().Healthy())
+	})
+	t.Run("Environment", func(t *testing.T) {
+		config := file.NewConfiguration()
+		err := config.FromEnvs(map[string]string{
+			"FILE_DIRECTORY":     "./tmp",
+			"STASH_TIME_TO_LIVE": "5m",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "./tmp", config.Directory)
+		assert.Equal(t, 5*time.Minute, config.TimeToLive)
+		err = config.FromEnvs(map[string]string{
+			"STASH_MAX_SIZE":      "large",
+			"STASH_DEBUG_ENABLED": "maybe",
+		})
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 2)
+		}
+		err = file.New().Configure(map[string]string{"STASH_TIME_TO_LIVE": "forever"})
+		assert.ErrorAs(t, err, &configurationError)
+	})
+}
@@ -7,6 +7,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/redis/go-redis/v9 v9.2.0
 	github.com/stretchr/testify v1.8.0
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
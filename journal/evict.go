@@ -0,0 +1,90 @@
+package journal
+
+import "container/heap"
+
+// expiryItem describes an item within the expiry heap
+type expiryItem struct {
+	key         string
+	lastUpdated int64
+	index       int
+}
+
+// expiry is a min-heap of items sorted by when they were last
+// updated, the item at the top of the heap is the next to expire
+type expiry struct {
+	items  []*expiryItem
+	lookup map[string]*expiryItem
+}
+
+func newExpiry() *expiry {
+	return &expiry{lookup: make(map[string]*expiryItem)}
+}
+
+func (e *expiry) Len() int {
+	return len(e.items)
+}
+
+func (e *expiry) Less(i, j int) bool {
+	return e.items[i].lastUpdated < e.items[j].lastUpdated
+}
+
+func (e *expiry) Swap(i, j int) {
+	e.items[i], e.items[j] = e.items[j], e.items[i]
+	e.items[i].index, e.items[j].index = i, j
+}
+
+func (e *expiry) Push(x any) {
+	item := x.(*expiryItem)
+	item.index = len(e.items)
+	e.items = append(e.items, item)
+}
+
+func (e *expiry) Pop() any {
+	n := len(e.items)
+	item := e.items[n-1]
+	e.items[n-1] = nil
+	e.items = e.items[:n-1]
+	return item
+}
+
+// add will add the item with the given key to the heap, if the
+// item is already in the heap, it's moved to its new position
+func (e *expiry) add(key string, lastUpdated int64) {
+	if item, found := e.lookup[key]; found {
+		item.lastUpdated = lastUpdated
+		heap.Fix(e, item.index)
+		return
+	}
+	item := &expiryItem{key: key, lastUpdated: lastUpdated}
+	e.lookup[key] = item
+	heap.Push(e, item)
+}
+
+func (e *expiry) remove(key string) {
+	if item, found := e.lookup[key]; found {
+		heap.Remove(e, item.index)
+		delete(e.lookup, key)
+	}
+}
+
+// expired will remove and return the keys of the items last updated
+// before the given time, the exclude key will never be returned
+func (e *expiry) expired(before int64, exclude string) []string {
+	var keys []string
+	var skipped *expiryItem
+
+	for e.Len() > 0 && e.items[0].lastUpdated < before {
+		item := heap.Pop(e).(*expiryItem)
+		delete(e.lookup, item.key)
+		if item.key == exclude {
+			skipped = item
+			continue
+		}
+		keys = append(keys, item.key)
+	}
+	if skipped != nil {
+		e.lookup[skipped.key] = skipped
+		heap.Push(e, skipped)
+	}
+	return keys
+}
@@ -0,0 +1,219 @@
+package journal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antonio-alexander/go-stash"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+
+	//headerSize is the size of the fixed portion of a record:
+	// crc32 (4) | op (1) | key length (4) | meta length (4) | bytes length (4)
+	headerSize int64 = 17
+
+	segmentExtension string = ".seg"
+	compactExtension string = ".compact"
+	tempExtension    string = ".tmp"
+)
+
+var (
+	errCorrupt = errors.New("corrupt record")
+	crcTable   = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// record describes a single entry in a segment, the bytes of a
+// put record are stored separately from its metadata so they can
+// be read directly from the segment
+type record struct {
+	op     byte
+	key    string
+	item   *stash.CachedItem
+	bytes  []byte
+	length int64
+}
+
+// segment describes a single file containing records, only the
+// active segment is ever appended to
+type segment struct {
+	id        uint64
+	path      string
+	file      *os.File
+	size      int64
+	dead      int64
+	compacted bool
+}
+
+func parseKey(key any) (string, error) {
+	switch key := key.(type) {
+	default:
+		return "", errors.Errorf("unsupported key type: %T", key)
+	case string:
+		return key, nil
+	}
+}
+
+func segmentPath(directory string, id uint64, compacted bool) string {
+	extension := segmentExtension
+	if compacted {
+		extension = compactExtension
+	}
+	return filepath.Join(directory, fmt.Sprintf("%016d%s", id, extension))
+}
+
+// listSegments will return the segments found within the directory
+// sorted by their id, temporary files are returned separately so they
+// can be removed
+func listSegments(directory string) ([]*segment, []string, error) {
+	var segments []*segment
+	var temporary []string
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, tempExtension) {
+			temporary = append(temporary, filepath.Join(directory, name))
+			continue
+		}
+		extension := filepath.Ext(name)
+		if extension != segmentExtension && extension != compactExtension {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, extension), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{
+			id:        id,
+			path:      filepath.Join(directory, name),
+			compacted: extension == compactExtension,
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].id == segments[j].id {
+			return segments[i].compacted
+		}
+		return segments[i].id < segments[j].id
+	})
+	return segments, temporary, nil
+}
+
+// encodeRecord will create the bytes for a record, the checksum is
+// calculated over everything that follows it
+func encodeRecord(op byte, key string, cachedItem *stash.CachedItem) ([]byte, error) {
+	var meta, bytes []byte
+
+	if op == opPut {
+		header := *cachedItem
+		header.Bytes = nil
+		b, err := json.Marshal(&header)
+		if err != nil {
+			return nil, err
+		}
+		meta, bytes = b, cachedItem.Bytes
+	}
+	buffer := make([]byte, headerSize+int64(len(key)+len(meta)+len(bytes)))
+	buffer[4] = op
+	binary.LittleEndian.PutUint32(buffer[5:9], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buffer[9:13], uint32(len(meta)))
+	binary.LittleEndian.PutUint32(buffer[13:17], uint32(len(bytes)))
+	n := copy(buffer[headerSize:], key)
+	n += copy(buffer[headerSize+int64(n):], meta)
+	copy(buffer[headerSize+int64(n):], bytes)
+	binary.LittleEndian.PutUint32(buffer[0:4], crc32.Checksum(buffer[4:], crcTable))
+	return buffer, nil
+}
+
+// decodeRecord will read a single record from the reader; io.EOF is
+// returned if there are no more records, any partial or invalid record
+// will return errCorrupt
+// KIM: the lengths within the header are validated against the number
+// of bytes remaining in the segment before the payload is allocated, so
+// a corrupt header can't cause a huge allocation
+func decodeRecord(reader io.Reader, remaining int64) (*record, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errCorrupt
+	}
+	op := header[4]
+	keyLength := int64(binary.LittleEndian.Uint32(header[5:9]))
+	metaLength := int64(binary.LittleEndian.Uint32(header[9:13]))
+	bytesLength := int64(binary.LittleEndian.Uint32(header[13:17]))
+	if op != opPut && op != opDelete {
+		return nil, errCorrupt
+	}
+	if keyLength+metaLength+bytesLength > remaining-headerSize {
+		return nil, errCorrupt
+	}
+	payload := make([]byte, keyLength+metaLength+bytesLength)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, errCorrupt
+	}
+	checksum := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, payload)
+	if checksum != binary.LittleEndian.Uint32(header[0:4]) {
+		return nil, errCorrupt
+	}
+	r := &record{
+		op:     op,
+		key:    string(payload[:keyLength]),
+		length: headerSize + int64(len(payload)),
+	}
+	if op == opPut {
+		var cachedItem stash.CachedItem
+
+		if err := json.Unmarshal(payload[keyLength:keyLength+metaLength], &cachedItem); err != nil {
+			return nil, errCorrupt
+		}
+		r.item, r.bytes = &cachedItem, payload[keyLength+metaLength:]
+	}
+	return r, nil
+}
+
+// bytesOffset returns the offset of the bytes of a put record relative
+// to the start of the record
+func bytesOffset(r *record) int64 {
+	return r.length - int64(len(r.bytes))
+}
+
+func toSlice(index map[string]*entry) []*stash.CachedItem {
+	cachedItems := make([]*stash.CachedItem, 0, len(index))
+	for _, entry := range index {
+		cachedItems = append(cachedItems, entry.item)
+	}
+	return cachedItems
+}
+
+// checkDirectory will return an error if the given directory can't be
+// accessed or isn't a directory
+func checkDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("not a directory: %s", directory)
+	}
+	return nil
+}
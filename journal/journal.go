@@ -0,0 +1,761 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-stash"
+	"github.com/antonio-alexander/go-stash/memory"
+
+	"github.com/pkg/errors"
+)
+
+// entry describes where the latest version of an item can be
+// found within the segments
+type entry struct {
+	item    *stash.CachedItem
+	segment *segment
+	offset  int64
+	length  int64
+}
+
+type stashJournal struct {
+	sync.Mutex
+	sync.WaitGroup
+	logger      stash.Logger
+	stopper     chan struct{}
+	index       map[string]*entry
+	policy      stash.Evictor
+	expiry      *expiry
+	segments    map[uint64]*segment
+	active      *segment
+	nextID      uint64
+	status      stash.Status
+	statusLock  sync.Mutex
+	config      *Configuration
+	size        int
+	initialized bool
+	configured  bool
+}
+
+// New can be used to create a concrete instance of a journal
+// cache/stash; items are appended to segment files and an index
+// of the latest version of each item is kept in memory
+func New(parameters ...any) interface {
+	stash.Stasher
+	stash.Configurer
+	stash.Initializer
+	stash.Shutdowner
+	stash.Parameterizer
+	stash.HealthChecker
+} {
+	s := &stashJournal{
+		index:    make(map[string]*entry),
+		segments: make(map[uint64]*segment),
+	}
+	s.SetParameters(parameters...)
+	return s
+}
+
+func (s *stashJournal) printf(format string, a ...any) {
+	if s.logger != nil && s.config != nil && s.config.Debug {
+		s.logger.Printf(s.config.DebugPrefix+format, a...)
+	}
+}
+
+// rotate will create a new active segment, the previously active
+// segment is synced and is only used for reads from then on
+func (s *stashJournal) rotate() error {
+	if s.active != nil {
+		if err := s.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+	path := segmentPath(s.config.Directory, s.nextID, false)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = &segment{id: s.nextID, path: path, file: file}
+	s.segments[s.active.id] = s.active
+	s.nextID++
+	return nil
+}
+
+// append will write a record to the active segment, if the item is
+// being put, an entry describing its location is returned
+func (s *stashJournal) append(op byte, key string, cachedItem *stash.CachedItem) (*entry, error) {
+	buffer, err := encodeRecord(op, key, cachedItem)
+	if err != nil {
+		return nil, err
+	}
+	active := s.active
+	if _, err := active.file.Write(buffer); err != nil {
+		//KIM: remove any partially written record so that
+		// subsequent records aren't lost on replay
+		if err := active.file.Truncate(active.size); err != nil {
+			s.printf("error while truncating segment %s: %s\n", active.path, err)
+		}
+		return nil, err
+	}
+	if s.config.SyncWrites {
+		if err := active.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	active.size += int64(len(buffer))
+	var e *entry
+	switch op {
+	case opPut:
+		item := *cachedItem
+		item.Bytes = nil
+		e = &entry{
+			item:    &item,
+			segment: active,
+			offset:  active.size - int64(len(cachedItem.Bytes)),
+			length:  int64(len(buffer)),
+		}
+	case opDelete:
+		active.dead += int64(len(buffer))
+	}
+	if s.config.SegmentSize > 0 && active.size >= s.config.SegmentSize {
+		if err := s.rotate(); err != nil {
+			s.printf("error while rotating segment: %s\n", err)
+		}
+	}
+	return e, nil
+}
+
+func (s *stashJournal) remove(key string) error {
+	e, found := s.index[key]
+	if !found {
+		return nil
+	}
+	if _, err := s.append(opDelete, key, nil); err != nil {
+		return err
+	}
+	e.segment.dead += e.length
+	delete(s.index, key)
+	s.policy.OnDelete(key)
+	s.expiry.remove(key)
+	s.size -= e.item.Size
+	return nil
+}
+
+// track will re-create the eviction policy and the expiry from the
+// index, the items are sorted so that the eviction policy tracks them
+// in the same order it would have if they had been written in order
+// KIM: the eviction policies are the evictors of the memory stash
+func (s *stashJournal) track() {
+	cachedItems := toSlice(s.index)
+	switch s.config.EvictionPolicy {
+	default:
+		sort.Sort(stash.ByFirstCreated(cachedItems))
+	case stash.LeastRecentlyUsed:
+		sort.Sort(stash.ByLastRead(cachedItems))
+	case stash.LeastFrequentlyUsed:
+		sort.Sort(stash.ByTimesRead(cachedItems))
+	}
+	s.policy, s.expiry = memory.NewEvictor(s.config.EvictionPolicy), newExpiry()
+	for _, cachedItem := range cachedItems {
+		key, _ := parseKey(cachedItem.Key)
+		s.policy.OnWrite(cachedItem)
+		s.expiry.add(key, cachedItem.LastUpdated)
+	}
+}
+
+// evict will remove any items whose time to live has been exceeded
+// and then (using the eviction policy) remove items until the size of
+// the stash is below the max size; the exclude key is never evicted so
+// that the item most recently accessed is always available
+func (s *stashJournal) evict(exclude string) {
+	if s.config.TimeToLive > 0 {
+		before := time.Now().Add(-s.config.TimeToLive).UnixNano()
+		for _, key := range s.expiry.expired(before, exclude) {
+			if err := s.remove(key); err != nil {
+				s.expiry.add(key, s.index[key].item.LastUpdated)
+				s.printf("error while evicting: %s\n", err.Error())
+				continue
+			}
+			s.printf("evicted key: %v, ttl exceeded\n", key)
+		}
+	}
+	//ensure we don't evict the only data that's in the
+	// stash even if we're above the max limit
+	for s.config.MaxSize > 0 && s.size > s.config.MaxSize && len(s.index) > 1 {
+		victim, ok := s.policy.Victim(exclude)
+		if !ok {
+			return
+		}
+		key, _ := parseKey(victim)
+		if err := s.remove(key); err != nil {
+			s.policy.OnWrite(s.index[key].item)
+			s.printf("error while evicting: %s\n", err.Error())
+			return
+		}
+		s.printf("evicted key: %v, max size exceeded\n", key)
+	}
+}
+
+// replay will read all of the records within the segment and apply
+// them to the index; if a corrupt (or partially written) record is
+// found, the segment is truncated at that record
+func (s *stashJournal) replay(seg *segment) error {
+	file, err := os.OpenFile(seg.path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for {
+		r, err := decodeRecord(reader, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.printf("truncating segment %s at %d: %s\n", seg.path, offset, err)
+			if err := os.Truncate(seg.path, offset); err != nil {
+				file.Close()
+				return err
+			}
+			break
+		}
+		if e, found := s.index[r.key]; found {
+			e.segment.dead += e.length
+			s.size -= e.item.Size
+			delete(s.index, r.key)
+		}
+		switch r.op {
+		case opPut:
+			s.index[r.key] = &entry{
+				item:    r.item,
+				segment: seg,
+				offset:  offset + bytesOffset(r),
+				length:  r.length,
+			}
+			s.size += r.item.Size
+		case opDelete:
+			seg.dead += r.length
+		}
+		offset += r.length
+	}
+	seg.file, seg.size = file, offset
+	return nil
+}
+
+// load will remove any segments that have been superseded by a
+// compacted segment and then replay the remaining segments in order
+func (s *stashJournal) load() error {
+	segments, temporary, err := listSegments(s.config.Directory)
+	if err != nil {
+		return err
+	}
+	for _, path := range temporary {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	compacted, latest := false, uint64(0)
+	for _, seg := range segments {
+		if seg.compacted {
+			compacted, latest = true, seg.id
+		}
+		if seg.id >= s.nextID {
+			s.nextID = seg.id + 1
+		}
+	}
+	for _, seg := range segments {
+		if compacted && (seg.id < latest || (seg.id == latest && !seg.compacted)) {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.replay(seg); err != nil {
+			return err
+		}
+		s.segments[seg.id] = seg
+	}
+	for id, seg := range s.segments {
+		if seg.size > 0 {
+			continue
+		}
+		if err := seg.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		delete(s.segments, id)
+	}
+	return nil
+}
+
+// compact will rewrite the live records of every segment except the
+// active segment into a single compacted segment, overwritten, deleted
+// and expired records are dropped; the records are copied without holding
+// the lock and the index is only updated for items that weren't modified
+// while copying
+func (s *stashJournal) compact() error {
+	type liveItem struct {
+		key   string
+		entry *entry
+		item  stash.CachedItem
+	}
+
+	var inputs []*segment
+	var live []liveItem
+	var total, dead int64
+	var outputID uint64
+
+	s.Lock()
+	s.evict("")
+	for _, seg := range s.segments {
+		total, dead = total+seg.size, dead+seg.dead
+	}
+	if dead == 0 || float64(dead) < s.config.CompactionThreshold*float64(total) {
+		s.Unlock()
+		return nil
+	}
+	if s.active.size > 0 {
+		if err := s.rotate(); err != nil {
+			s.Unlock()
+			return err
+		}
+	}
+	for _, seg := range s.segments {
+		if seg == s.active {
+			continue
+		}
+		inputs = append(inputs, seg)
+		if seg.id > outputID {
+			outputID = seg.id
+		}
+	}
+	for key, e := range s.index {
+		if e.segment != s.active {
+			live = append(live, liveItem{key: key, entry: e, item: *e.item})
+		}
+	}
+	path := segmentPath(s.config.Directory, outputID, true)
+	s.Unlock()
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	offsets, lengths := make([]int64, len(live)), make([]int64, len(live))
+	file, err := os.Create(path + tempExtension)
+	if err != nil {
+		return err
+	}
+	size, err := func() (int64, error) {
+		defer file.Close()
+
+		writer, offset := bufio.NewWriter(file), int64(0)
+		for i, l := range live {
+			bytes := make([]byte, l.item.Size)
+			if _, err := l.entry.segment.file.ReadAt(bytes, l.entry.offset); err != nil {
+				return 0, err
+			}
+			l.item.Bytes = bytes
+			buffer, err := encodeRecord(opPut, l.key, &l.item)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := writer.Write(buffer); err != nil {
+				return 0, err
+			}
+			offsets[i] = offset + int64(len(buffer)-len(bytes))
+			lengths[i] = int64(len(buffer))
+			offset += int64(len(buffer))
+		}
+		if err := writer.Flush(); err != nil {
+			return 0, err
+		}
+		return offset, file.Sync()
+	}()
+	if err != nil {
+		os.Remove(path + tempExtension)
+		return err
+	}
+	if err := os.Rename(path+tempExtension, path); err != nil {
+		os.Remove(path + tempExtension)
+		return err
+	}
+	if file, err = os.Open(path); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	//KIM: if the stash was cleared (or shutdown) while copying, the
+	// compacted segment is no longer valid
+	for _, input := range inputs {
+		if s.segments[input.id] != input {
+			file.Close()
+			return os.Remove(path)
+		}
+	}
+	output := &segment{id: outputID, path: path, file: file, size: size, compacted: true}
+	for i, l := range live {
+		if s.index[l.key] != l.entry {
+			output.dead += lengths[i]
+			continue
+		}
+		l.entry.segment, l.entry.offset, l.entry.length = output, offsets[i], lengths[i]
+	}
+	for _, input := range inputs {
+		if err := input.file.Close(); err != nil {
+			s.printf("error while closing segment %s: %s\n", input.path, err)
+		}
+		if err := os.Remove(input.path); err != nil {
+			s.printf("error while removing segment %s: %s\n", input.path, err)
+		}
+		delete(s.segments, input.id)
+	}
+	s.segments[output.id] = output
+	s.printf("compacted %d segments into %s, %d items\n", len(inputs), path, len(live))
+	return nil
+}
+
+func (s *stashJournal) launchCompaction() {
+	if s.config.CompactionRate <= 0 {
+		s.printf("compaction go routine disabled\n")
+		return
+	}
+	started := make(chan struct{})
+	s.Add(1)
+	go func() {
+		defer s.Done()
+
+		tCompact := time.NewTicker(s.config.CompactionRate)
+		defer tCompact.Stop()
+		close(started)
+		for {
+			select {
+			case <-s.stopper:
+				return
+			case <-tCompact.C:
+				if err := s.compact(); err != nil {
+					s.printf("error while compacting: %s\n", err)
+				}
+			}
+		}
+	}()
+	<-started
+}
+
+func (s *stashJournal) close() {
+	for id, seg := range s.segments {
+		if err := seg.file.Sync(); err != nil {
+			s.printf("error while syncing segment %s: %s\n", seg.path, err)
+		}
+		if err := seg.file.Close(); err != nil {
+			s.printf("error while closing segment %s: %s\n", seg.path, err)
+		}
+		delete(s.segments, id)
+	}
+	s.active = nil
+}
+
+// Configure
+func (s *stashJournal) Configure(items ...any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var config *Configuration
+
+	for _, item := range items {
+		switch item := item.(type) {
+		case *Configuration:
+			config = item
+		case Configuration:
+			config = &item
+		case map[string]string:
+			config = &Configuration{}
+			config.Default()
+			if err := config.FromEnvs(item); err != nil {
+				return err
+			}
+		}
+	}
+	if config != nil {
+		previous := s.config
+		s.config = config
+		s.configured = true
+		if s.initialized && previous.EvictionPolicy != config.EvictionPolicy {
+			s.track()
+		}
+	}
+
+	return nil
+}
+
+// SetParameters
+func (s *stashJournal) SetParameters(items ...any) {
+	for _, item := range items {
+		switch item := item.(type) {
+		case stash.Logger:
+			s.logger = item
+		}
+	}
+}
+
+// Initialize can be used to setup internal pointers
+// and ready the stash for usage; the index is rebuilt
+// by replaying any segments found in the configured
+// directory
+func (s *stashJournal) Initialize() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.configured {
+		return errors.New("not configured")
+	}
+	if s.initialized {
+		return errors.New("already initialized")
+	}
+	if s.config.Directory == "" {
+		return errors.New("directory not configured")
+	}
+	if err := os.MkdirAll(s.config.Directory, 0755); err != nil {
+		return err
+	}
+	s.size, s.nextID = 0, 1
+	s.index = make(map[string]*entry)
+	s.segments = make(map[uint64]*segment)
+	if err := s.load(); err != nil {
+		s.close()
+		return err
+	}
+	if err := s.rotate(); err != nil {
+		s.close()
+		return err
+	}
+	s.track()
+	if s.config.MaxSize > 0 {
+		maxSize := float64(s.config.MaxSize) / 1024 / 1024
+		s.printf("configured max size: %fMB\n", maxSize)
+	}
+	if s.config.TimeToLive > 0 {
+		s.printf("configured time to live: %v\n", s.config.TimeToLive)
+	}
+	s.printf("configured eviction policy: %s\n", s.config.EvictionPolicy)
+	s.printf("loaded %d items from %d segments\n", len(s.index), len(s.segments)-1)
+	s.evict("")
+	s.stopper = make(chan struct{})
+	s.launchCompaction()
+	s.initialized = true
+
+	return nil
+}
+
+// Shutdown can be used to tear down internal pointers
+// and ready the stash for garbage collection (or reuse)
+// KIM: segments are left on disk so they can be replayed
+// by a subsequent call to Initialize
+func (s *stashJournal) Shutdown() error {
+	s.Lock()
+	if !s.initialized {
+		s.Unlock()
+		return nil
+	}
+	close(s.stopper)
+	s.Unlock()
+
+	//KIM: compaction requires the lock, so we have to wait
+	// for it to stop without holding it
+	s.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.close()
+	s.size = 0
+	s.index = make(map[string]*entry)
+	s.initialized, s.configured = false, false
+
+	return nil
+}
+
+// Ping can be used to check whether the stash is usable, an
+// error is returned if the stash hasn't been initialized or
+// its directory can't be accessed
+func (s *stashJournal) Ping(ctx context.Context) error {
+	tStart := time.Now()
+	err := ctx.Err()
+	if err == nil {
+		s.Lock()
+		switch {
+		default:
+			err = checkDirectory(s.config.Directory)
+		case !s.initialized:
+			err = errors.New("not initialized")
+		}
+		s.Unlock()
+	}
+	s.statusLock.Lock()
+	s.status = stash.NewStatus(tStart, err)
+	s.statusLock.Unlock()
+	return err
+}
+
+// Status returns the result of the last ping
+func (s *stashJournal) Status() stash.Status {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	return s.status
+}
+
+// Write can be used to create/update a value in the cache with the given
+// key. If the value exists, replaced will be true
+func (s *stashJournal) Write(key any, item stash.Cacheable) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return false, errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return false, err
+	}
+	var cachedItem *stash.CachedItem
+	existing, found := s.index[field]
+	if found {
+		updatedItem := *existing.item
+		if err := stash.UpdateCacheItem(&updatedItem, item); err != nil {
+			return false, err
+		}
+		cachedItem = &updatedItem
+	} else {
+		cachedItem, err = stash.CreateCacheItem(field, item)
+		if err != nil {
+			return false, err
+		}
+	}
+	e, err := s.append(opPut, field, cachedItem)
+	if err != nil {
+		return false, err
+	}
+	if found {
+		existing.segment.dead += existing.length
+		s.size -= existing.item.Size
+	}
+	s.index[field] = e
+	s.policy.OnWrite(e.item)
+	s.expiry.add(field, e.item.LastUpdated)
+	s.size += e.item.Size
+	if found {
+		s.printf("updated key: %v\n", key)
+	} else {
+		s.printf("created key: %v\n", key)
+	}
+	s.evict(field)
+
+	return found, nil
+}
+
+// Read can be used to read a value in the cache with the given key
+// if the value exists, it will be unmarshalled into the Cacheable
+// pointer; this is expected to work very much like an Unmarshal
+// function. If a value isn't found with the given key, an error
+// will be returned
+// KIM: the read statistics are only kept in the index, they're
+// persisted the next time the item is written or compacted
+func (s *stashJournal) Read(key any, v stash.Cacheable) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	e, found := s.index[field]
+	if !found {
+		return errors.Errorf("value for %v not found", key)
+	}
+	bytes := make([]byte, e.item.Size)
+	if _, err := e.segment.file.ReadAt(bytes, e.offset); err != nil {
+		return err
+	}
+	e.item.LastRead = time.Now().UnixNano()
+	e.item.NTimesRead++
+	s.policy.OnRead(e.item)
+	if err := v.UnmarshalBinary(bytes); err != nil {
+		return err
+	}
+	s.printf("read key: %v\n", key)
+	s.evict(field)
+
+	return nil
+}
+
+// Delete can be used to remove a value from the cache with a given
+// key. If the value isn't found, an error is returned.
+func (s *stashJournal) Delete(key any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.index[field]; !ok {
+		return errors.Errorf("value not found for key: %v", key)
+	}
+	if err := s.remove(field); err != nil {
+		return err
+	}
+	s.printf("deleted key: %v\n", key)
+	s.evict("")
+
+	return nil
+}
+
+func (s *stashJournal) Clear() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	for _, seg := range s.segments {
+		path := seg.path
+		if err := seg.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	s.segments = make(map[uint64]*segment)
+	s.active = nil
+	s.size = 0
+	s.index = make(map[string]*entry)
+	s.track()
+	if err := s.rotate(); err != nil {
+		return err
+	}
+	s.printf("cleared cache\n")
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package journal_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antonio-alexander/go-stash"
+	"github.com/antonio-alexander/go-stash/internal"
+	"github.com/antonio-alexander/go-stash/journal"
+	"github.com/antonio-alexander/go-stash/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStashJournal(t *testing.T) {
+	const debug = true
+
+	newStash := func(config journal.Configuration) interface {
+		stash.Stasher
+		stash.Shutdowner
+	} {
+		logger := internal.NewLogger()
+		j := journal.New()
+		j.SetParameters(logger)
+		err := j.Configure(config)
+		assert.Nil(t, err)
+		err = j.Initialize()
+		assert.Nil(t, err)
+		return j
+	}
+	t.Run("Stash", tests.TestStash(t, func() stash.Stasher {
+		return newStash(journal.Configuration{Directory: t.TempDir()})
+	}))
+	t.Run("Evict Size", tests.TestEvictSize(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:   t.TempDir(),
+				TimeToLive:  timeToLive,
+				MaxSize:     maxSize,
+				Debug:       debug,
+				DebugPrefix: "[stash] ",
+			})
+		}))
+	t.Run("Evict Least Recently Used", tests.TestEvictLeastRecentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.LeastRecentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Least Frequently Used", tests.TestEvictLeastFrequentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.LeastFrequentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict First In First Out", tests.TestEvictFirstInFirstOut(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.FirstInFirstOut,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Adaptive Replacement", tests.TestEvictAdaptiveReplacement(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.AdaptiveReplacement,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Sieve", tests.TestEvictSecondChance(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(journal.Configuration{
+				Directory:      t.TempDir(),
+				EvictionPolicy: stash.Sieve,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Time To Live", func(t *testing.T) {
+		const timeToLive = 500 * time.Millisecond
+
+		config := journal.Configuration{Directory: t.TempDir(), TimeToLive: timeToLive}
+		example := &stash.Example{Int: 1, String: "expire"}
+
+		//write two examples and update the second so that it
+		// expires after the first
+		s := newStash(config)
+		for _, key := range []string{"key1", "key2"} {
+			_, err := s.Write(key, example)
+			assert.Nil(t, err)
+		}
+		time.Sleep(timeToLive * 3 / 5)
+		_, err := s.Write("key2", example)
+		assert.Nil(t, err)
+		time.Sleep(timeToLive * 3 / 5)
+		_, err = s.Write("key3", example)
+		assert.Nil(t, err)
+		err = s.Read("key1", &stash.Example{})
+		assert.NotNil(t, err)
+		err = s.Read("key2", &stash.Example{})
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//validate that the expiry is rebuilt from the segments
+		s = newStash(config)
+		time.Sleep(timeToLive * 3 / 5)
+		_, err = s.Write("key4", example)
+		assert.Nil(t, err)
+		err = s.Read("key2", &stash.Example{})
+		assert.NotNil(t, err)
+		err = s.Read("key3", &stash.Example{})
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Replay", func(t *testing.T) {
+		config := journal.Configuration{Directory: t.TempDir(), SegmentSize: 256}
+		examples := map[string]*stash.Example{
+			"key1": {Int: 1, String: "one"},
+			"key2": {Int: 2, String: "two"},
+			"key3": {Int: 3, String: "three"},
+		}
+
+		//write (and overwrite) the examples, delete one and shutdown
+		s := newStash(config)
+		for i := 0; i < 3; i++ {
+			for key, example := range examples {
+				_, err := s.Write(key, example)
+				assert.Nil(t, err)
+			}
+		}
+		err := s.Delete("key3")
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//validate that the index is rebuilt from the segments
+		s = newStash(config)
+		for _, key := range []string{"key1", "key2"} {
+			exampleRead := &stash.Example{}
+			err = s.Read(key, exampleRead)
+			assert.Nil(t, err)
+			assert.Equal(t, examples[key], exampleRead)
+		}
+		err = s.Read("key3", &stash.Example{})
+		assert.NotNil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Compaction", func(t *testing.T) {
+		config := journal.Configuration{
+			Directory:           t.TempDir(),
+			SegmentSize:         256,
+			CompactionRate:      10 * time.Millisecond,
+			CompactionThreshold: 0.5,
+		}
+		example := &stash.Example{Int: 1, String: "compact"}
+
+		//overwrite the same key enough times to create multiple segments
+		s := newStash(config)
+		for i := 0; i < 50; i++ {
+			_, err := s.Write("key", example)
+			assert.Nil(t, err)
+		}
+		countFiles := func() int {
+			files, err := filepath.Glob(filepath.Join(config.Directory, "*"))
+			assert.Nil(t, err)
+			return len(files)
+		}
+		nSegments := countFiles()
+		assert.Greater(t, nSegments, 2)
+		assert.Eventually(t, func() bool {
+			return countFiles() <= 2
+		}, time.Second, 10*time.Millisecond)
+		exampleRead := &stash.Example{}
+		err := s.Read("key", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, example, exampleRead)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//validate the compacted segment can be replayed
+		s = newStash(config)
+		exampleRead = &stash.Example{}
+		err = s.Read("key", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, example, exampleRead)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Corruption", func(t *testing.T) {
+		config := journal.Configuration{Directory: t.TempDir()}
+		example := &stash.Example{Int: 1, String: "corrupt"}
+
+		//write an example and shutdown
+		s := newStash(config)
+		_, err := s.Write("key", example)
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//simulate a partially written record
+		files, err := filepath.Glob(filepath.Join(config.Directory, "*.seg"))
+		assert.Nil(t, err)
+		assert.Len(t, files, 1)
+		file, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x03})
+		assert.Nil(t, err)
+		err = file.Close()
+		assert.Nil(t, err)
+
+		//validate that the valid record is still readable
+		s = newStash(config)
+		exampleRead := &stash.Example{}
+		err = s.Read("key", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, example, exampleRead)
+		_, err = s.Write("key", example)
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Corruption Length", func(t *testing.T) {
+		config := journal.Configuration{Directory: t.TempDir()}
+		example := &stash.Example{Int: 1, String: "corrupt"}
+
+		//write an example and shutdown
+		s := newStash(config)
+		_, err := s.Write("key", example)
+		assert.Nil(t, err)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+
+		//simulate a record whose header has a corrupt length, if the
+		// length were trusted, replaying would allocate ~12GB
+		files, err := filepath.Glob(filepath.Join(config.Directory, "*.seg"))
+		assert.Nil(t, err)
+		assert.Len(t, files, 1)
+		info, err := os.Stat(files[0])
+		assert.Nil(t, err)
+		file, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x01,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			'k', 'e', 'y'})
+		assert.Nil(t, err)
+		err = file.Close()
+		assert.Nil(t, err)
+
+		//validate that the segment was truncated and the valid record
+		// is still readable
+		s = newStash(config)
+		infoReplayed, err := os.Stat(files[0])
+		assert.Nil(t, err)
+		assert.Equal(t, info.Size(), infoReplayed.Size())
+		exampleRead := &stash.Example{}
+		err = s.Read("key", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, example, exampleRead)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Health Check", func(t *testing.T) {
+		s := newStash(journal.Configuration{Directory: t.TempDir()})
+		tests.TestHealthCheck(t, func() stash.HealthChecker {
+			return s.(stash.HealthChecker)
+		}, func() stash.HealthChecker {
+			return journal.New()
+		})(t)
+		err := s.(stash.Shutdowner).Shutdown()
+		assert.Nil(t, err)
+		err = s.(stash.Pinger).Ping(context.Background())
+		assert.NotNil(t, err)
+		assert.False(t, s.(stash.HealthChecker).Status().Healthy())
+	})
+	t.Run("Environment", func(t *testing.T) {
+		config := journal.NewConfiguration()
+		err := config.FromEnvs(map[string]string{
+			"JOURNAL_SEGMENT_SIZE":    "1024",
+			"JOURNAL_COMPACTION_RATE": "10",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1024), config.SegmentSize)
+		assert.Equal(t, 10*time.Second, config.CompactionRate)
+		err = config.FromEnvs(map[string]string{
+			"JOURNAL_SEGMENT_SIZE":         "large",
+			"JOURNAL_COMPACTION_THRESHOLD": "half",
+			"STASH_DEBUG_PREFIX":           "[journal] ",
+		})
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 2)
+		}
+		err = journal.New().Configure(map[string]string{"STASH_TIME_TO_LIVE": "forever"})
+		assert.ErrorAs(t, err, &configurationError)
+	})
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"hash/maphash"
+)
+
+const (
+	sketchDepth    int   = 4
+	sketchWidth    int   = 1 << 14
+	sketchMaxCount uint8 = 15
+)
+
+// sketch is a count-min sketch used to estimate how often a key
+// has been accessed; once the number of increments reaches the
+// sample size, every counter is halved so that the estimate ages
+// and keys that were popular a long time ago can be evicted
+type sketch struct {
+	seed       maphash.Seed
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newSketch() *sketch {
+	s := &sketch{
+		seed:       maphash.MakeSeed(),
+		mask:       uint64(sketchWidth - 1),
+		sampleSize: 10 * sketchWidth,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, sketchWidth)
+	}
+	return s
+}
+
+// indexes will return the index of the counter for the key
+// within each row using double hashing
+func (s *sketch) indexes(key any) [sketchDepth]uint64 {
+	var indexes [sketchDepth]uint64
+
+	h := hashKey(s.seed, key)
+	h1, h2 := h, (h>>32)|(h<<32)|1
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return indexes
+}
+
+// increment will increment the counters for the given key
+// KIM: this is a conservative update, only the counters that
+// are equal to the current estimate are incremented which
+// reduces the error caused by collisions
+func (s *sketch) increment(key any) {
+	indexes := s.indexes(key)
+	estimate := s.estimate(key)
+	if estimate >= sketchMaxCount {
+		return
+	}
+	for i, index := range indexes {
+		if s.rows[i][index] == estimate {
+			s.rows[i][index]++
+		}
+	}
+	if s.additions++; s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+// estimate will return the estimated number of times the
+// key has been accessed
+func (s *sketch) estimate(key any) uint8 {
+	estimate := sketchMaxCount
+	for i, index := range s.indexes(key) {
+		if count := s.rows[i][index]; count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+func (s *sketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
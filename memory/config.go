@@ -1,10 +1,14 @@
 package memory
 
 import (
+	"flag"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/antonio-alexander/go-stash"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -12,16 +16,26 @@ const (
 	defaultTimeToLive     time.Duration        = 30 * time.Second
 	defaultMaxSize        int                  = 10
 	defaultDebugEnabled   bool                 = true
+	defaultShards         int                  = 1
+	defaultAdmission      bool                 = false
+	defaultAdmissionRatio float64              = 0.01
+	defaultEvictionRate   time.Duration        = 0
+	defaultEvictionBatch  int                  = 1000
 )
 
 // Configuration describes what can be configured for the
 // memory stash
 type Configuration struct {
-	EvictionPolicy stash.EvictionPolicy `json:"eviction_policy"`
-	TimeToLive     time.Duration        `json:"time_to_live"`
-	MaxSize        int                  `json:"max_size"`
-	Debug          bool                 `json:"debug"`
-	DebugPrefix    string               `json:"debug_prefix"`
+	EvictionPolicy stash.EvictionPolicy `json:"eviction_policy" usage:"the policy used to evict items when the stash is full"`
+	TimeToLive     time.Duration        `json:"time_to_live" usage:"how long an item is kept after it was last updated (0 disables)"`
+	MaxSize        int                  `json:"max_size" usage:"the maximum size of the items in bytes (0 is unlimited)"`
+	Shards         int                  `json:"shards" usage:"the number of shards the items are split across"`
+	Admission      bool                 `json:"admission" usage:"only replace an evicted item with a new item that is estimated to be used more often"`
+	AdmissionRatio float64              `json:"admission_ratio" usage:"the portion of the max size used for the admission window"`
+	EvictionRate   time.Duration        `json:"eviction_rate" usage:"how often expired items are evicted in the background (0 disables)"`
+	EvictionBatch  int                  `json:"eviction_batch" usage:"the maximum number of items evicted in the background at once"`
+	Debug          bool                 `json:"debug" usage:"enable debug logging"`
+	DebugPrefix    string               `json:"debug_prefix" usage:"the prefix of debug log messages"`
 }
 
 func NewConfiguration() *Configuration {
@@ -29,12 +43,25 @@ func NewConfiguration() *Configuration {
 		EvictionPolicy: defaultEvictionPolicy,
 		TimeToLive:     defaultTimeToLive,
 		MaxSize:        defaultMaxSize,
+		Shards:         defaultShards,
+		Admission:      defaultAdmission,
+		AdmissionRatio: defaultAdmissionRatio,
+		EvictionRate:   defaultEvictionRate,
+		EvictionBatch:  defaultEvictionBatch,
 		Debug:          defaultDebugEnabled,
 	}
 }
 
-func (c *Configuration) FromEnvs(envs map[string]string) {
+// FromEnvs will set the configuration using the given environmental
+// variables, an error is returned for each value that can't be parsed;
+// durations can be provided as seconds (e.g. 30) or as a duration
+// string (e.g. 30s)
+func (c *Configuration) FromEnvs(envs map[string]string) error {
+	var errs stash.ConfigurationError
+
 	for key, value := range envs {
+		var err error
+
 		if value == "" {
 			continue
 		}
@@ -42,16 +69,115 @@ func (c *Configuration) FromEnvs(envs map[string]string) {
 		case "STASH_EVICTION_POLICY":
 			c.EvictionPolicy = stash.EvictionPolicy(value)
 		case "STASH_TIME_TO_LIVE":
-			t, _ := strconv.Atoi(value)
-			c.TimeToLive = time.Second * time.Duration(t)
+			c.TimeToLive, err = stash.ParseDuration(value)
 		case "STASH_MAX_SIZE":
-			c.MaxSize, _ = strconv.Atoi(value)
+			c.MaxSize, err = strconv.Atoi(value)
+		case "STASH_SHARDS":
+			c.Shards, err = strconv.Atoi(value)
+		case "STASH_ADMISSION":
+			c.Admission, err = strconv.ParseBool(value)
+		case "STASH_ADMISSION_RATIO":
+			c.AdmissionRatio, err = strconv.ParseFloat(value, 64)
+		case "STASH_EVICTION_RATE":
+			c.EvictionRate, err = stash.ParseDuration(value)
+		case "STASH_EVICTION_BATCH":
+			c.EvictionBatch, err = strconv.Atoi(value)
 		case "STASH_DEBUG_ENABLED":
-			c.Debug, _ = strconv.ParseBool(value)
+			c.Debug, err = strconv.ParseBool(value)
 		case "STASH_DEBUG_PREFIX":
 			c.DebugPrefix = value
 		}
+		if err != nil {
+			errs.Add(key, err)
+		}
+	}
+	return errs.Err()
+}
+
+// Validate will return an error describing each invalid field, zero
+// values are valid (e.g. an empty eviction policy is first in first
+// out and a max size of zero is unlimited)
+func (c *Configuration) Validate() error {
+	var errs stash.ConfigurationError
+
+	switch c.EvictionPolicy {
+	default:
+		errs.Add("eviction_policy", errors.Errorf("unknown eviction policy: %q", c.EvictionPolicy))
+	case "", stash.LeastRecentlyUsed, stash.LeastFrequentlyUsed, stash.FirstInFirstOut,
+		stash.AdaptiveReplacement, stash.Sieve, stash.Clock:
+	}
+	for field, value := range map[string]time.Duration{
+		"time_to_live":  c.TimeToLive,
+		"eviction_rate": c.EvictionRate,
+	} {
+		if value < 0 {
+			errs.Add(field, errors.New("must not be negative"))
+		}
+	}
+	for field, value := range map[string]int{
+		"max_size":       c.MaxSize,
+		"shards":         c.Shards,
+		"eviction_batch": c.EvictionBatch,
+	} {
+		if value < 0 {
+			errs.Add(field, errors.New("must not be negative"))
+		}
+	}
+	if c.AdmissionRatio < 0 || c.AdmissionRatio > 1 {
+		errs.Add("admission_ratio", errors.New("must be between 0 and 1"))
+	}
+	return errs.Err()
+}
+
+// FromEnviron will set the configuration using the environmental
+// variables of the process (see FromEnvs), if a prefix is provided, only
+// the variables with the prefix are used (e.g. with the prefix APP_,
+// APP_STASH_MAX_SIZE is used)
+func (c *Configuration) FromEnviron(prefix string) error {
+	return c.FromEnvs(stash.Environ(prefix))
+}
+
+// FromFile will set the configuration using the given json file, fields
+// that aren't present keep their values; durations can be provided as
+// strings (e.g. "30s") or as a number of nanoseconds
+func (c *Configuration) FromFile(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return stash.UnmarshalConfiguration(bytes, c)
+}
+
+// RegisterFlags will register a flag for each field of the configuration
+// onto the given flag set (e.g. -max-size), each flag is prefixed with
+// the given prefix
+func (c *Configuration) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	stash.RegisterFlags(fs, prefix, c)
+}
+
+// Load will set the configuration using the following sources, each
+// source takes precedence over the sources before it:
+//
+//  1. the current values (e.g. the defaults)
+//  2. the json file at the given path (if not empty)
+//  3. the environmental variables of the process with the given prefix
+//  4. the flags (registered using RegisterFlags) that were set on the
+//     given flag set (if not nil)
+//
+// the configuration is validated once every source has been applied
+func (c *Configuration) Load(path, prefix string, fs *flag.FlagSet) error {
+	if path != "" {
+		if err := c.FromFile(path); err != nil {
+			return err
+		}
+	}
+	if err := c.FromEnviron(prefix); err != nil {
+		return err
+	}
+	if fs != nil {
+		stash.ApplyFlags(fs, c)
 	}
+	return c.Validate()
 }
 
 func (c *Configuration) Default() {
@@ -62,5 +188,10 @@ func (c *Configuration) Default() {
 	c.EvictionPolicy = defaultEvictionPolicy
 	c.TimeToLive = defaultTimeToLive
 	c.MaxSize = defaultMaxSize
+	c.Shards = defaultShards
+	c.Admission = defaultAdmission
+	c.AdmissionRatio = defaultAdmissionRatio
+	c.EvictionRate = defaultEvictionRate
+	c.EvictionBatch = defaultEvictionBatch
 	c.Debug = defaultDebugEnabled
 }
@@ -0,0 +1,566 @@
+package memory
+
+import (
+	"container/list"
+	"sync/atomic"
+
+	"github.com/antonio-alexander/go-stash"
+)
+
+// peeker describes an evictor that can return the next victim
+// without evicting it, this is required for the admission filter
+type peeker interface {
+	Peek(exclude any) (any, bool)
+}
+
+// visitor describes an evictor that only needs to mark an item as
+// visited when it's read, this can be done while holding a shared
+// lock so reads don't have to be serialized
+type visitor interface {
+	visit(key any)
+}
+
+// NewEvictor can be used to create one of the built-in evictors
+// for the given eviction policy, first in first out is used if the
+// eviction policy is unknown; these evictors aren't safe for
+// concurrent use
+func NewEvictor(evictionPolicy stash.EvictionPolicy) stash.Evictor {
+	switch evictionPolicy {
+	default:
+		return newQueue(false)
+	case stash.LeastRecentlyUsed:
+		return newQueue(true)
+	case stash.LeastFrequentlyUsed:
+		return newFrequency()
+	case stash.AdaptiveReplacement:
+		return newAdaptive()
+	case stash.Sieve:
+		return newHand(true)
+	case stash.Clock:
+		return newHand(false)
+	}
+}
+
+// queue is a doubly linked list where the front of the list is
+// the next victim; if touched is true items are moved to the back
+// of the list when read (least recently used) otherwise items are
+// kept in the order they were added (first in first out)
+type queue struct {
+	items   *list.List
+	lookup  map[any]*list.Element
+	touched bool
+}
+
+func newQueue(touched bool) *queue {
+	return &queue{
+		items:   list.New(),
+		lookup:  make(map[any]*list.Element),
+		touched: touched,
+	}
+}
+
+// contains will return true if the item with the given key
+// is in the queue, a nil queue contains nothing
+func (q *queue) contains(key any) bool {
+	if q == nil {
+		return false
+	}
+	_, found := q.lookup[key]
+	return found
+}
+
+func (q *queue) OnWrite(cachedItem *stash.CachedItem) {
+	if q.contains(cachedItem.Key) {
+		return
+	}
+	q.lookup[cachedItem.Key] = q.items.PushBack(cachedItem.Key)
+}
+
+func (q *queue) OnRead(cachedItem *stash.CachedItem) {
+	if !q.touched {
+		return
+	}
+	if element, found := q.lookup[cachedItem.Key]; found {
+		q.items.MoveToBack(element)
+	}
+}
+
+func (q *queue) OnDelete(key any) {
+	if element, found := q.lookup[key]; found {
+		q.items.Remove(element)
+		delete(q.lookup, key)
+	}
+}
+
+func (q *queue) Peek(exclude any) (any, bool) {
+	for element := q.items.Front(); element != nil; element = element.Next() {
+		if key := element.Value; key != exclude {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (q *queue) Victim(exclude any) (any, bool) {
+	key, ok := q.Peek(exclude)
+	if ok {
+		q.OnDelete(key)
+	}
+	return key, ok
+}
+
+// frequencyNode contains all of the items that have been read
+// the same number of times, in the order they reached that count
+type frequencyNode struct {
+	count int
+	items *list.List
+}
+
+// frequencyItem describes where an item can be found
+type frequencyItem struct {
+	key     any
+	node    *list.Element
+	element *list.Element
+}
+
+// frequency is a list of frequency nodes sorted by their count
+// (least frequently used), the next victim is the oldest item in
+// the first node
+type frequency struct {
+	nodes  *list.List
+	lookup map[any]*frequencyItem
+}
+
+func newFrequency() *frequency {
+	return &frequency{
+		nodes:  list.New(),
+		lookup: make(map[any]*frequencyItem),
+	}
+}
+
+// insert will add the item to the node with the given count, searching
+// from the given node (a nil node is the back of the list)
+func (f *frequency) insert(item *frequencyItem, count int, from *list.Element) {
+	for ; from != nil; from = from.Next() {
+		node := from.Value.(*frequencyNode)
+		if node.count == count {
+			item.node, item.element = from, node.items.PushBack(item)
+			return
+		}
+		if node.count > count {
+			break
+		}
+	}
+	node := &frequencyNode{count: count, items: list.New()}
+	if from == nil {
+		item.node = f.nodes.PushBack(node)
+	} else {
+		item.node = f.nodes.InsertBefore(node, from)
+	}
+	item.element = node.items.PushBack(item)
+}
+
+// detach will remove the item from its node, if the node is empty it
+// will be removed; the element following the node is returned
+func (f *frequency) detach(item *frequencyItem) *list.Element {
+	node := item.node.Value.(*frequencyNode)
+	node.items.Remove(item.element)
+	next := item.node.Next()
+	if node.items.Len() == 0 {
+		f.nodes.Remove(item.node)
+		return next
+	}
+	return item.node
+}
+
+func (f *frequency) OnWrite(cachedItem *stash.CachedItem) {
+	if _, found := f.lookup[cachedItem.Key]; found {
+		return
+	}
+	item := &frequencyItem{key: cachedItem.Key}
+	f.lookup[cachedItem.Key] = item
+	f.insert(item, cachedItem.NTimesRead, f.nodes.Front())
+}
+
+func (f *frequency) OnRead(cachedItem *stash.CachedItem) {
+	item, found := f.lookup[cachedItem.Key]
+	if !found {
+		return
+	}
+	count := item.node.Value.(*frequencyNode).count + 1
+	f.insert(item, count, f.detach(item))
+}
+
+func (f *frequency) OnDelete(key any) {
+	if item, found := f.lookup[key]; found {
+		f.detach(item)
+		delete(f.lookup, key)
+	}
+}
+
+func (f *frequency) Peek(exclude any) (any, bool) {
+	for node := f.nodes.Front(); node != nil; node = node.Next() {
+		items := node.Value.(*frequencyNode).items
+		for element := items.Front(); element != nil; element = element.Next() {
+			if key := element.Value.(*frequencyItem).key; key != exclude {
+				return key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (f *frequency) Victim(exclude any) (any, bool) {
+	key, ok := f.Peek(exclude)
+	if ok {
+		f.OnDelete(key)
+	}
+	return key, ok
+}
+
+// adaptive implements the adaptive replacement cache (ARC), items
+// seen once are kept in a recency list (t1) and items seen more than
+// once are kept in a frequency list (t2); the keys of items evicted
+// from each list are remembered in ghost lists (b1 and b2) and a hit
+// on a ghost list adjusts the target size of t1 (p) so the policy tunes
+// itself between recency and frequency
+// KIM: ARC is described in terms of the number of items that fit in
+// the cache, since the stash is limited by size, the number of items
+// currently in the stash is used as the capacity
+type adaptive struct {
+	t1, t2, b1, b2 *queue
+	p              int
+	ghostHit       bool
+}
+
+func newAdaptive() *adaptive {
+	return &adaptive{
+		t1: newQueue(false),
+		t2: newQueue(false),
+		b1: newQueue(false),
+		b2: newQueue(false),
+	}
+}
+
+func (a *adaptive) capacity() int {
+	if c := a.t1.items.Len() + a.t2.items.Len(); c > 0 {
+		return c
+	}
+	return 1
+}
+
+// ghost will remember the key in the given ghost list, the ghost
+// list is trimmed so that it doesn't exceed the capacity
+func (a *adaptive) ghost(ghosts *queue, key any) {
+	ghosts.lookup[key] = ghosts.items.PushBack(key)
+	for ghosts.items.Len() > a.capacity() {
+		ghosts.OnDelete(ghosts.items.Front().Value)
+	}
+}
+
+func (a *adaptive) OnWrite(cachedItem *stash.CachedItem) {
+	key := cachedItem.Key
+	if a.t1.contains(key) || a.t2.contains(key) {
+		return
+	}
+	_, inB1 := a.b1.lookup[key]
+	_, inB2 := a.b2.lookup[key]
+	a.ghostHit = inB2
+	switch {
+	default:
+		a.t1.OnWrite(cachedItem)
+	case inB1:
+		delta := 1
+		if a.b2.items.Len() > a.b1.items.Len() {
+			delta = a.b2.items.Len() / a.b1.items.Len()
+		}
+		if a.p += delta; a.p > a.capacity() {
+			a.p = a.capacity()
+		}
+		a.b1.OnDelete(key)
+		a.t2.OnWrite(cachedItem)
+	case inB2:
+		delta := 1
+		if a.b1.items.Len() > a.b2.items.Len() {
+			delta = a.b1.items.Len() / a.b2.items.Len()
+		}
+		if a.p -= delta; a.p < 0 {
+			a.p = 0
+		}
+		a.b2.OnDelete(key)
+		a.t2.OnWrite(cachedItem)
+	}
+}
+
+func (a *adaptive) OnRead(cachedItem *stash.CachedItem) {
+	key := cachedItem.Key
+	if _, found := a.t1.lookup[key]; found {
+		a.t1.OnDelete(key)
+		a.t2.OnWrite(cachedItem)
+		return
+	}
+	if element, found := a.t2.lookup[key]; found {
+		a.t2.items.MoveToBack(element)
+	}
+}
+
+func (a *adaptive) OnDelete(key any) {
+	a.t1.OnDelete(key)
+	a.t2.OnDelete(key)
+}
+
+// replace will return the list the next victim should be taken
+// from followed by its ghost list, the second list (and its ghost
+// list) should be used if the first list has no victim
+func (a *adaptive) replace() (*queue, *queue, *queue, *queue) {
+	t1Length := a.t1.items.Len()
+	if t1Length > 0 && (t1Length > a.p || (a.ghostHit && t1Length == a.p)) {
+		return a.t1, a.b1, a.t2, a.b2
+	}
+	return a.t2, a.b2, a.t1, a.b1
+}
+
+func (a *adaptive) Peek(exclude any) (any, bool) {
+	first, _, second, _ := a.replace()
+	if key, ok := first.Peek(exclude); ok {
+		return key, true
+	}
+	return second.Peek(exclude)
+}
+
+func (a *adaptive) Victim(exclude any) (any, bool) {
+	first, firstGhosts, second, secondGhosts := a.replace()
+	if key, ok := first.Victim(exclude); ok {
+		a.ghost(firstGhosts, key)
+		return key, true
+	}
+	if key, ok := second.Victim(exclude); ok {
+		a.ghost(secondGhosts, key)
+		return key, true
+	}
+	return nil, false
+}
+
+// handItem describes an item tracked by a hand, visited is
+// atomic so it can be set while holding a shared lock
+type handItem struct {
+	key     any
+	visited atomic.Bool
+}
+
+// hand implements both the SIEVE and CLOCK eviction policies, items
+// are kept in a list and reads only mark an item as visited; to choose
+// a victim, the hand moves through the list clearing the visited flag
+// until it finds an item that hasn't been visited
+// KIM: with SIEVE, new items are added to the front of the list and the
+// hand moves from the back to the front (retained items aren't moved),
+// with CLOCK, the list is a circle and new items are added just behind
+// the hand so they're the last to be examined
+type hand struct {
+	items  *list.List
+	lookup map[any]*list.Element
+	hand   *list.Element
+	sieve  bool
+}
+
+func newHand(sieve bool) *hand {
+	return &hand{
+		items:  list.New(),
+		lookup: make(map[any]*list.Element),
+		sieve:  sieve,
+	}
+}
+
+// next will return the element the hand should move to after the
+// given element, wrapping around the list
+func (h *hand) next(element *list.Element) *list.Element {
+	if h.sieve {
+		if element = element.Prev(); element == nil {
+			element = h.items.Back()
+		}
+		return element
+	}
+	if element = element.Next(); element == nil {
+		element = h.items.Front()
+	}
+	return element
+}
+
+func (h *hand) start() *list.Element {
+	switch {
+	case h.hand != nil:
+		return h.hand
+	case h.sieve:
+		return h.items.Back()
+	default:
+		return h.items.Front()
+	}
+}
+
+func (h *hand) OnWrite(cachedItem *stash.CachedItem) {
+	if _, found := h.lookup[cachedItem.Key]; found {
+		return
+	}
+	item := &handItem{key: cachedItem.Key}
+	switch {
+	case h.sieve:
+		h.lookup[item.key] = h.items.PushFront(item)
+	case h.hand != nil:
+		h.lookup[item.key] = h.items.InsertBefore(item, h.hand)
+	default:
+		h.lookup[item.key] = h.items.PushBack(item)
+	}
+}
+
+func (h *hand) OnRead(cachedItem *stash.CachedItem) {
+	h.visit(cachedItem.Key)
+}
+
+func (h *hand) visit(key any) {
+	if element, found := h.lookup[key]; found {
+		element.Value.(*handItem).visited.Store(true)
+	}
+}
+
+func (h *hand) OnDelete(key any) {
+	element, found := h.lookup[key]
+	if !found {
+		return
+	}
+	if h.hand == element {
+		if h.hand = h.next(element); h.hand == element {
+			h.hand = nil
+		}
+	}
+	h.items.Remove(element)
+	delete(h.lookup, key)
+}
+
+// candidates returns true if there's at least one item that
+// could be evicted
+func (h *hand) candidates(exclude any) bool {
+	_, excluded := h.lookup[exclude]
+	return h.items.Len() > 1 || (h.items.Len() == 1 && !excluded)
+}
+
+// Peek will move the hand to the next victim without evicting
+// it, clearing the visited flag of the items it passes (as Victim
+// would) so that the hand never has to pass them again
+func (h *hand) Peek(exclude any) (any, bool) {
+	if !h.candidates(exclude) {
+		return nil, false
+	}
+	h.hand = h.advance(exclude)
+	return h.hand.Value.(*handItem).key, true
+}
+
+func (h *hand) Victim(exclude any) (any, bool) {
+	if !h.candidates(exclude) {
+		return nil, false
+	}
+	h.hand = h.advance(exclude)
+	key := h.hand.Value.(*handItem).key
+	h.OnDelete(key)
+	return key, true
+}
+
+// advance will move through the list from the hand, clearing the
+// visited flag of each item, until it finds an item that hasn't been
+// visited; there must be at least one candidate
+func (h *hand) advance(exclude any) *list.Element {
+	element := h.start()
+	for {
+		item := element.Value.(*handItem)
+		if item.key != exclude {
+			if !item.visited.Load() {
+				return element
+			}
+			item.visited.Store(false)
+		}
+		element = h.next(element)
+	}
+}
+
+// expiryItem describes an item tracked by expiry
+type expiryItem struct {
+	key         any
+	lastUpdated int64
+}
+
+// expiry is a list of items sorted by when they were last updated,
+// the item at the front of the list is the next to expire
+// KIM: items are almost always added (or updated) with the current
+// time, so the position of an item is searched for from the back of
+// the list and is found immediately
+type expiry struct {
+	items  *list.List
+	lookup map[any]*list.Element
+}
+
+func newExpiry() *expiry {
+	return &expiry{
+		items:  list.New(),
+		lookup: make(map[any]*list.Element),
+	}
+}
+
+// position will return the element an item last updated at the
+// given time should follow, nil means the front of the list
+func (e *expiry) position(lastUpdated int64) *list.Element {
+	element := e.items.Back()
+	for element != nil && element.Value.(*expiryItem).lastUpdated > lastUpdated {
+		element = element.Prev()
+	}
+	return element
+}
+
+func (e *expiry) add(cachedItem *stash.CachedItem) {
+	item := &expiryItem{key: cachedItem.Key, lastUpdated: cachedItem.LastUpdated}
+	if mark := e.position(item.lastUpdated); mark != nil {
+		e.lookup[item.key] = e.items.InsertAfter(item, mark)
+		return
+	}
+	e.lookup[item.key] = e.items.PushFront(item)
+}
+
+func (e *expiry) update(cachedItem *stash.CachedItem) {
+	element, found := e.lookup[cachedItem.Key]
+	if !found {
+		return
+	}
+	element.Value.(*expiryItem).lastUpdated = cachedItem.LastUpdated
+	if mark := e.position(cachedItem.LastUpdated); mark != nil {
+		e.items.MoveAfter(element, mark)
+		return
+	}
+	e.items.MoveToFront(element)
+}
+
+func (e *expiry) remove(key any) {
+	if element, found := e.lookup[key]; found {
+		e.items.Remove(element)
+		delete(e.lookup, key)
+	}
+}
+
+// expired will return the keys of the items last updated before
+// the given time (at most limit keys if limit is greater than zero),
+// the exclude key will never be returned
+func (e *expiry) expired(before int64, exclude any, limit int) []any {
+	var keys []any
+
+	element := e.items.Front()
+	for element != nil && element.Value.(*expiryItem).lastUpdated < before {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		next, item := element.Next(), element.Value.(*expiryItem)
+		if item.key != exclude {
+			e.items.Remove(element)
+			delete(e.lookup, item.key)
+			keys = append(keys, item.key)
+		}
+		element = next
+	}
+	return keys
+}
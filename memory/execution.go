@@ -1,6 +1,9 @@
 package memory
 
 import (
+	"fmt"
+	"hash/maphash"
+
 	"github.com/antonio-alexander/go-stash"
 )
 
@@ -11,3 +14,18 @@ func toSlice(items map[any]*stash.CachedItem) []*stash.CachedItem {
 	}
 	return cachedItems
 }
+
+// hashKey will hash the key using the given seed, keys that aren't
+// strings are hashed using their default format
+func hashKey(seed maphash.Seed, key any) uint64 {
+	var h maphash.Hash
+
+	h.SetSeed(seed)
+	switch key := key.(type) {
+	case string:
+		h.WriteString(key)
+	default:
+		fmt.Fprint(&h, key)
+	}
+	return h.Sum64()
+}
@@ -1,8 +1,11 @@
 package memory
 
 import (
+	"context"
+	"hash/maphash"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/antonio-alexander/go-stash"
@@ -10,14 +13,87 @@ import (
 	"github.com/pkg/errors"
 )
 
+// shard is a portion of the stash with its own lock, keys
+// are hashed to determine which shard they belong to
+type shard struct {
+	sync.RWMutex
+	data       map[any]*stash.CachedItem
+	policy     stash.Evictor
+	expiry     *expiry
+	window     *queue
+	sketch     *sketch
+	windowSize int
+	size       int
+	shared     bool
+}
+
+func newShard(config *Configuration, evictor stash.Evictor) *shard {
+	sh := &shard{
+		data:   make(map[any]*stash.CachedItem),
+		policy: evictor,
+		expiry: newExpiry(),
+	}
+	if config != nil && config.Admission {
+		sh.window, sh.sketch = newQueue(true), newSketch()
+	}
+	return sh
+}
+
+func (sh *shard) add(cachedItem *stash.CachedItem) {
+	sh.data[cachedItem.Key] = cachedItem
+	sh.policy.OnWrite(cachedItem)
+	sh.size += cachedItem.Size
+}
+
+// victim will return the next item to evict from the shard, the window
+// is only used if there are no other items
+func (sh *shard) victim(exclude any) (any, bool) {
+	if key, ok := sh.policy.Victim(exclude); ok {
+		return key, true
+	}
+	if sh.window != nil {
+		return sh.window.Peek(exclude)
+	}
+	return nil, false
+}
+
+// release will notify the evictor that every item it tracks was
+// removed, it's only necessary if the evictor was provided (shared)
+// since it outlives the shard
+func (sh *shard) release() {
+	if !sh.shared {
+		return
+	}
+	for key := range sh.data {
+		if !sh.window.contains(key) {
+			sh.policy.OnDelete(key)
+		}
+	}
+}
+
+// access will record that the item with the given key was accessed
+func (sh *shard) access(key any) {
+	if sh.sketch != nil {
+		sh.sketch.increment(key)
+	}
+}
+
 type stashMemory struct {
-	sync.Mutex
+	sync.RWMutex
+	sync.WaitGroup
 	logger      stash.Logger
-	data        map[any]*stash.CachedItem
+	stopper     chan struct{}
+	evictor     stash.Evictor
+	newEvictor  stash.EvictorFunc
+	shards      []*shard
+	seed        maphash.Seed
+	status      stash.Status
+	statusLock  sync.Mutex
 	config      *Configuration
-	size        int
+	size        int64
 	initialized bool
 	configured  bool
+	stopping    bool
 }
 
 // New can be used to create a concrete instance of a memory
@@ -30,10 +106,12 @@ func New(parameters ...any) interface {
 	stash.Initializer
 	stash.Shutdowner
 	stash.Parameterizer
+	stash.HealthChecker
 } {
 	s := &stashMemory{
-		data: make(map[any]*stash.CachedItem),
+		seed: maphash.MakeSeed(),
 	}
+	s.reshard()
 	s.SetParameters(parameters...)
 	return s
 }
@@ -44,41 +122,260 @@ func (s *stashMemory) printf(format string, a ...any) {
 	}
 }
 
-func (s *stashMemory) evict() {
-	evictionPolicy := s.config.EvictionPolicy
-	cacheItems := toSlice(s.data)
+// reshard will create the configured number of shards, any data that
+// exists will be moved to the shard its key hashes to
+// KIM: the items are sorted before being moved so that the new shards
+// track them in the same order as the eviction policy would have, they're
+// also sorted by when they were last updated so that each item is added
+// to the back of the expiry
+func (s *stashMemory) reshard() {
+	var evictionPolicy stash.EvictionPolicy
+	var cacheItems []*stash.CachedItem
+
+	n := 1
+	if s.config != nil {
+		evictionPolicy = s.config.EvictionPolicy
+		if s.config.Shards > 0 {
+			n = s.config.Shards
+		}
+	}
+	if s.evictor != nil && n > 1 {
+		s.printf("evictor provided, using a single shard rather than %d\n", n)
+		n = 1
+	}
+	shards := make([]*shard, 0, n)
+	for i := 0; i < n; i++ {
+		var shared bool
+
+		evictor := s.evictor
+		switch {
+		case s.newEvictor != nil:
+			evictor = s.newEvictor()
+		case evictor == nil:
+			evictor = NewEvictor(evictionPolicy)
+		default:
+			shared = true
+		}
+		sh := newShard(s.config, evictor)
+		sh.shared = shared
+		shards = append(shards, sh)
+	}
+	//KIM: a provided evictor is released before the items are moved
+	// so that it doesn't track an item twice
+	for _, sh := range s.shards {
+		sh.release()
+		cacheItems = append(cacheItems, toSlice(sh.data)...)
+	}
+	sort.Sort(stash.ByLastUpdated(cacheItems))
+	for _, cacheItem := range cacheItems {
+		shards[hashKey(s.seed, cacheItem.Key)%uint64(n)].expiry.add(cacheItem)
+	}
 	switch evictionPolicy {
-	case stash.FirstInFirstOut:
+	default:
 		sort.Sort(stash.ByFirstCreated(cacheItems))
 	case stash.LeastRecentlyUsed:
 		sort.Sort(stash.ByLastRead(cacheItems))
 	case stash.LeastFrequentlyUsed:
 		sort.Sort(stash.ByTimesRead(cacheItems))
 	}
-	//ensure we don't evict the only data that's in the
-	// stash even if we're above the max limit because
-	// there's only a single item in the stash
+	for _, cacheItem := range cacheItems {
+		shards[hashKey(s.seed, cacheItem.Key)%uint64(n)].add(cacheItem)
+	}
+	s.shards = shards
+}
 
-	//ensure that we don't evict items unnecessarily, we shuoldn't if:
-	// - we haven't exceeded the max size and it's greater than 0
-	// - the time to live is greater than 0
-	if (s.config.MaxSize > 0 && (s.config.MaxSize-s.size) > 0) ||
-		s.config.TimeToLive > 0 {
+// reset will remove every item by re-creating the shards, a provided
+// evictor is notified of each item removed
+func (s *stashMemory) reset() {
+	for _, sh := range s.shards {
+		sh.release()
+	}
+	s.shards = nil
+	s.reshard()
+	atomic.StoreInt64(&s.size, 0)
+}
+
+func (s *stashMemory) shard(key any) *shard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	return s.shards[hashKey(s.seed, key)%uint64(len(s.shards))]
+}
+
+// remove will remove the item from the shard, the evictor is only
+// notified if the item wasn't chosen by the evictor as a victim
+func (s *stashMemory) remove(sh *shard, key any, victim bool) {
+	cachedItem, found := sh.data[key]
+	if !found {
 		return
 	}
-	tNow := time.Now()
-	for _, cacheItem := range cacheItems {
-		switch {
-		case s.config.MaxSize > 0 && s.size > s.config.MaxSize:
-			s.size = s.size - cacheItem.Size
-			delete(s.data, cacheItem.Key)
-			s.printf("evicted key: %v, max size exceeded\n", cacheItem.Key)
-		case s.config.TimeToLive > 0 && tNow.Sub(time.Unix(0, cacheItem.LastUpdated)) > s.config.TimeToLive:
-			s.size = s.size - cacheItem.Size
-			delete(s.data, cacheItem.Key)
-			s.printf("evicted key: %v, ttl exceeded\n", cacheItem.Key)
+	if sh.window.contains(key) {
+		sh.window.OnDelete(key)
+		sh.windowSize -= cachedItem.Size
+	}
+	delete(sh.data, key)
+	if !victim {
+		sh.policy.OnDelete(key)
+	}
+	sh.expiry.remove(key)
+	sh.size -= cachedItem.Size
+	atomic.AddInt64(&s.size, -int64(cachedItem.Size))
+}
+
+// evictSize will use the eviction policy to remove items from the
+// shard until the size of the stash is below the max size
+func (s *stashMemory) evictSize(sh *shard, exclude any) {
+	for atomic.LoadInt64(&s.size) > int64(s.config.MaxSize) {
+		key, ok := sh.victim(exclude)
+		if !ok {
+			return
+		}
+		s.remove(sh, key, true)
+		s.printf("evicted key: %v, max size exceeded\n", key)
+	}
+}
+
+// admit will add the item to the window of the shard, any items that
+// no longer fit within the window become candidates for the eviction
+// policy; if the stash is full, a candidate is only admitted if its
+// estimated frequency is higher than the next victim, otherwise the
+// candidate is evicted (this keeps a scan of items used once from
+// evicting items that are used often)
+func (s *stashMemory) admit(sh *shard, cacheItem *stash.CachedItem) {
+	sh.data[cacheItem.Key] = cacheItem
+	sh.expiry.add(cacheItem)
+	sh.window.OnWrite(cacheItem)
+	sh.windowSize += cacheItem.Size
+	sh.size += cacheItem.Size
+	windowMax := int(float64(s.config.MaxSize)*s.config.AdmissionRatio) / len(s.shards)
+	for sh.windowSize > windowMax {
+		candidate, ok := sh.window.Victim(cacheItem.Key)
+		if !ok {
+			return
+		}
+		candidateItem := sh.data[candidate]
+		sh.windowSize -= candidateItem.Size
+		if s.config.MaxSize <= 0 || atomic.LoadInt64(&s.size) <= int64(s.config.MaxSize) {
+			sh.policy.OnWrite(candidateItem)
+			continue
+		}
+		//KIM: if the evictor can't peek at its next victim, the
+		// candidate is always admitted
+		peeker, ok := sh.policy.(peeker)
+		if !ok {
+			sh.policy.OnWrite(candidateItem)
+			continue
+		}
+		victim, ok := peeker.Peek(cacheItem.Key)
+		if !ok || sh.sketch.estimate(candidate) > sh.sketch.estimate(victim) {
+			sh.policy.OnWrite(candidateItem)
+			continue
+		}
+		s.remove(sh, candidate, true)
+		s.printf("evicted key: %v, not admitted\n", candidate)
+	}
+}
+
+// evict will remove any items from the shard whose time to live has
+// been exceeded and then remove items until the size of the stash is
+// below the max size; the exclude key is never evicted so that the item
+// most recently accessed is always available (this ensures we don't evict
+// the only data in the stash even if it exceeds the max size)
+// KIM: the max size is global, so if evicting from the locked shard isn't
+// enough, we'll attempt to evict from the other shards without blocking
+func (s *stashMemory) evict(sh *shard, exclude any) {
+	if s.config.TimeToLive > 0 {
+		before := time.Now().Add(-s.config.TimeToLive).UnixNano()
+		for _, key := range sh.expiry.expired(before, exclude, 0) {
+			s.remove(sh, key, false)
+			s.printf("evicted key: %v, ttl exceeded\n", key)
+		}
+	}
+	if s.config.MaxSize <= 0 || atomic.LoadInt64(&s.size) <= int64(s.config.MaxSize) {
+		return
+	}
+	s.evictSize(sh, exclude)
+	for _, other := range s.shards {
+		if atomic.LoadInt64(&s.size) <= int64(s.config.MaxSize) {
+			return
+		}
+		if other == sh || !other.TryLock() {
+			continue
+		}
+		s.evictSize(other, exclude)
+		other.Unlock()
+	}
+}
+
+// expire will remove at most batch items whose time to live has been
+// exceeded, starting with the given shard; it returns the index of the
+// shard to start with on the next call so that every shard is visited
+// even if the batch is exhausted by a single shard
+func (s *stashMemory) expire(start, batch int) int {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized || s.config.TimeToLive <= 0 {
+		return start
+	}
+	before := time.Now().Add(-s.config.TimeToLive).UnixNano()
+	for i := 0; i < len(s.shards); i++ {
+		index := (start + i) % len(s.shards)
+		sh := s.shards[index]
+		sh.Lock()
+		keys := sh.expiry.expired(before, nil, batch)
+		for _, key := range keys {
+			s.remove(sh, key, false)
+			s.printf("evicted key: %v, ttl exceeded\n", key)
+		}
+		sh.Unlock()
+		if batch > 0 {
+			if batch -= len(keys); batch <= 0 {
+				return index
+			}
 		}
 	}
+	return start
+}
+
+// stopEvict will stop the eviction go routine (if running), the
+// stopper is only closed once
+func (s *stashMemory) stopEvict() {
+	if s.stopper == nil {
+		return
+	}
+	close(s.stopper)
+	s.stopper = nil
+}
+
+// launchEvict will start a go routine that will periodically remove
+// items whose time to live has been exceeded, without it, items are
+// only removed when the stash is accessed
+func (s *stashMemory) launchEvict() {
+	if s.config.EvictionRate <= 0 || s.config.TimeToLive <= 0 {
+		s.printf("eviction go routine disabled\n")
+		return
+	}
+	started := make(chan struct{})
+	s.Add(1)
+	go func(stopper <-chan struct{}, evictionRate time.Duration, evictionBatch int) {
+		defer s.Done()
+
+		var start int
+
+		tEvict := time.NewTicker(evictionRate)
+		defer tEvict.Stop()
+		close(started)
+		for {
+			select {
+			case <-stopper:
+				return
+			case <-tEvict.C:
+				start = s.expire(start, evictionBatch)
+			}
+		}
+	}(s.stopper, s.config.EvictionRate, s.config.EvictionBatch)
+	<-started
 }
 
 // Configure
@@ -97,23 +394,76 @@ func (s *stashMemory) Configure(items ...any) error {
 		case map[string]string:
 			config = &Configuration{}
 			config.Default()
-			config.FromEnvs(item)
+			if err := config.FromEnvs(item); err != nil {
+				return err
+			}
 		}
 	}
 	if config != nil {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		previous := s.config
 		s.config = config
 		s.configured = true
+		//KIM: resharding re-creates the evictors (losing what they've
+		// learned), so it's only done if the shards would be different
+		if previous == nil || previous.Shards != config.Shards ||
+			previous.EvictionPolicy != config.EvictionPolicy ||
+			previous.Admission != config.Admission {
+			s.reshard()
+		}
+		s.reconfigure(previous)
 	}
 
 	return nil
 }
 
+// reconfigure will apply the current configuration to the items that
+// already exist (evicting items until the new limits are met) and, if
+// the stash is initialized, restart the eviction go routine if its rate
+// or batch changed
+// KIM: the eviction go routine requires the lock, so we can't wait for
+// it to stop, since it was given its own stopper, closing it is enough;
+// the go routine isn't restarted while shutting down since Shutdown is
+// waiting for it to stop
+func (s *stashMemory) reconfigure(previous *Configuration) {
+	for _, sh := range s.shards {
+		sh.Lock()
+		s.evict(sh, nil)
+		sh.Unlock()
+	}
+	if !s.initialized || s.stopping || previous == nil {
+		return
+	}
+	if previous.EvictionRate == s.config.EvictionRate &&
+		previous.EvictionBatch == s.config.EvictionBatch &&
+		(previous.TimeToLive > 0) == (s.config.TimeToLive > 0) {
+		return
+	}
+	s.stopEvict()
+	s.stopper = make(chan struct{})
+	s.launchEvict()
+}
+
 // SetParameters
 func (s *stashMemory) SetParameters(items ...any) {
+	s.Lock()
+	defer s.Unlock()
+
 	for _, item := range items {
 		switch item := item.(type) {
 		case stash.Logger:
 			s.logger = item
+		case stash.Evictor:
+			s.evictor, s.newEvictor = item, nil
+			s.reshard()
+		case stash.EvictorFunc:
+			s.evictor, s.newEvictor = nil, item
+			s.reshard()
+		case func() stash.Evictor:
+			s.evictor, s.newEvictor = nil, item
+			s.reshard()
 		}
 	}
 }
@@ -132,13 +482,16 @@ func (s *stashMemory) Initialize() error {
 	}
 	if s.config.MaxSize > 0 {
 		maxSize := float64(s.config.MaxSize) / 1024 / 1024
-		s.printf("configured max size: %dMB\n", s.size, maxSize)
+		s.printf("configured max size: %fMB\n", maxSize)
 	}
 	if s.config.TimeToLive > 0 {
 		s.printf("configured time to live: %#v", s.config.TimeToLive)
 	}
 	s.printf("configured eviction policy: %s", s.config.EvictionPolicy)
-	s.size = 0
+	s.reset()
+	s.printf("configured shards: %d", len(s.shards))
+	s.stopper = make(chan struct{})
+	s.launchEvict()
 	s.initialized = true
 
 	return nil
@@ -148,42 +501,101 @@ func (s *stashMemory) Initialize() error {
 // and ready the stash for garbage collection (or reuse)
 func (s *stashMemory) Shutdown() error {
 	s.Lock()
-	defer s.Unlock()
-
-	if !s.initialized {
+	if !s.initialized || s.stopping {
+		s.Unlock()
 		return nil
 	}
-	s.size = 0
-	s.data = make(map[any]*stash.CachedItem)
-	s.initialized, s.configured = false, false
+	s.stopping = true
+	s.stopEvict()
+	s.Unlock()
+
+	//KIM: the eviction go routine requires the lock, so we
+	// have to wait for it to stop without holding it
+	s.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.reset()
+	s.initialized, s.configured, s.stopping = false, false, false
 
 	return nil
 }
 
+// Ping can be used to check whether the stash is usable, the stash
+// is usable once it's been configured and initialized
+func (s *stashMemory) Ping(ctx context.Context) error {
+	tStart := time.Now()
+	err := ctx.Err()
+	if err == nil {
+		//KIM: the lock is acquired to validate that the stash
+		// isn't deadlocked
+		s.RLock()
+		switch {
+		case !s.configured:
+			err = errors.New("not configured")
+		case !s.initialized:
+			err = errors.New("not initialized")
+		}
+		s.RUnlock()
+	}
+	s.statusLock.Lock()
+	s.status = stash.NewStatus(tStart, err)
+	s.statusLock.Unlock()
+	return err
+}
+
+// Status returns the result of the last ping
+func (s *stashMemory) Status() stash.Status {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	return s.status
+}
+
 // Write can be used to create/update a value in the cache with the given
 // key. If the value exists, replaced will be true
 func (s *stashMemory) Write(key any, item stash.Cacheable) (bool, error) {
-	s.Lock()
-	defer s.evict()
-	defer s.Unlock()
+	s.RLock()
+	defer s.RUnlock()
+
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
 
-	cacheItem, found := s.data[key]
+	cacheItem, found := sh.data[key]
 	if found {
-		s.size -= cacheItem.Size
+		size := cacheItem.Size
 		if err := stash.UpdateCacheItem(cacheItem, item); err != nil {
 			return false, err
 		}
-		s.size += cacheItem.Size
+		if sh.window.contains(key) {
+			sh.windowSize += cacheItem.Size - size
+		} else {
+			sh.policy.OnWrite(cacheItem)
+		}
+		sh.access(key)
+		sh.expiry.update(cacheItem)
+		sh.size += cacheItem.Size - size
+		atomic.AddInt64(&s.size, int64(cacheItem.Size-size))
 		s.printf("updated key: %v\n", key)
+		s.evict(sh, key)
 		return found, nil
 	}
 	cacheItem, err := stash.CreateCacheItem(key, item)
 	if err != nil {
 		return false, err
 	}
-	s.data[key] = cacheItem
-	s.size += cacheItem.Size
+	atomic.AddInt64(&s.size, int64(cacheItem.Size))
 	s.printf("created key: %v\n", key)
+	sh.access(key)
+	if sh.window != nil {
+		s.admit(sh, cacheItem)
+	} else {
+		sh.add(cacheItem)
+		sh.expiry.add(cacheItem)
+	}
+	s.evict(sh, key)
 
 	return found, nil
 }
@@ -194,38 +606,93 @@ func (s *stashMemory) Write(key any, item stash.Cacheable) (bool, error) {
 // function. If a value isn't found with the given key, an error
 // will be returned
 func (s *stashMemory) Read(key any, v stash.Cacheable) error {
-	s.Lock()
-	defer s.evict()
-	defer s.Unlock()
+	s.RLock()
+	defer s.RUnlock()
 
-	item, found := s.data[key]
+	sh := s.shard(key)
+	if visitor, ok := sh.policy.(visitor); ok && sh.sketch == nil {
+		if read, err := s.readShared(sh, visitor, key, v); read {
+			return err
+		}
+	}
+	sh.Lock()
+	defer sh.Unlock()
+
+	sh.access(key)
+	item, found := sh.data[key]
 	if !found {
 		return errors.Errorf("value for %v not found", key)
 	}
+	//KIM: evict never evicts the key being read, so an item whose time
+	// to live has been exceeded is removed here rather than returned
+	if s.expired(item) {
+		s.remove(sh, key, false)
+		s.printf("evicted key: %v, ttl exceeded\n", key)
+		return errors.Errorf("value for %v not found", key)
+	}
 	item.LastRead = time.Now().UnixNano()
 	item.NTimesRead++
-	bytes := make([]byte, len(item.Bytes))
-	copy(bytes, item.Bytes)
+	if sh.window.contains(key) {
+		sh.window.OnRead(item)
+	} else {
+		sh.policy.OnRead(item)
+	}
 	if err := v.UnmarshalBinary(item.Bytes); err != nil {
 		return err
 	}
 	s.printf("read key: %v\n", key)
+	s.evict(sh, key)
 
 	return nil
 }
 
+// expired returns true if the time to live of the item has been exceeded
+func (s *stashMemory) expired(item *stash.CachedItem) bool {
+	return s.config.TimeToLive > 0 && time.Since(time.Unix(0, item.LastUpdated)) > s.config.TimeToLive
+}
+
+// readShared will attempt to read the value while holding a shared
+// lock, this is only possible if the eviction policy only needs to
+// mark the item as visited; false is returned if the item has expired
+// and the read should be done while holding an exclusive lock
+// KIM: the read statistics of the item aren't updated since that
+// would require an exclusive lock
+func (s *stashMemory) readShared(sh *shard, visitor visitor, key any, v stash.Cacheable) (bool, error) {
+	sh.RLock()
+	defer sh.RUnlock()
+
+	item, found := sh.data[key]
+	if !found {
+		return true, errors.Errorf("value for %v not found", key)
+	}
+	if s.expired(item) {
+		return false, nil
+	}
+	visitor.visit(key)
+	if err := v.UnmarshalBinary(item.Bytes); err != nil {
+		return true, err
+	}
+	s.printf("read key: %v\n", key)
+
+	return true, nil
+}
+
 // Delete can be used to remove a value from the cache with a given
 // key. If the value isn't found, an error is returned.
 func (s *stashMemory) Delete(key any) error {
-	s.Lock()
-	defer s.evict()
-	defer s.Unlock()
+	s.RLock()
+	defer s.RUnlock()
+
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
 
-	if _, ok := s.data[key]; !ok {
+	if _, ok := sh.data[key]; !ok {
 		return errors.Errorf("value not found for key: %v", key)
 	}
-	delete(s.data, key)
+	s.remove(sh, key, false)
 	s.printf("deleted key: %v\n", key)
+	s.evict(sh, nil)
 
 	return nil
 }
@@ -238,8 +705,7 @@ func (s *stashMemory) Clear() error {
 	// it makes sense to re-create the pointer to
 	// trigger garbage collection instead; this is
 	// also...probably...slightly faster
-	s.data = nil
-	s.data = make(map[any]*stash.CachedItem)
+	s.reset()
 	s.printf("cleared cache")
 	return nil
 }
@@ -1,6 +1,14 @@
 package memory_test
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +20,65 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// cheapestFirst is an evictor that evicts the item that is the
+// cheapest to recompute (the Int of the example) first
+type cheapestFirst struct {
+	costs map[any]int
+}
+
+func (c *cheapestFirst) OnWrite(cachedItem *stash.CachedItem) {
+	example := &stash.Example{}
+	_ = example.UnmarshalBinary(cachedItem.Bytes)
+	c.costs[cachedItem.Key] = example.Int
+}
+
+func (c *cheapestFirst) OnRead(*stash.CachedItem) {}
+
+func (c *cheapestFirst) OnDelete(key any) {
+	delete(c.costs, key)
+}
+
+func (c *cheapestFirst) Victim(exclude any) (any, bool) {
+	var victim any
+
+	found := false
+	for key, cost := range c.costs {
+		if key != exclude && (!found || cost < c.costs[victim]) {
+			victim, found = key, true
+		}
+	}
+	delete(c.costs, victim)
+	return victim, found
+}
+
+// recorder is an evictor that records the number of times each
+// item is tracked (written without being deleted)
+type recorder struct {
+	tracked map[any]int
+}
+
+func (r *recorder) OnWrite(cachedItem *stash.CachedItem) {
+	r.tracked[cachedItem.Key]++
+}
+
+func (r *recorder) OnRead(*stash.CachedItem) {}
+
+func (r *recorder) OnDelete(key any) {
+	if r.tracked[key]--; r.tracked[key] <= 0 {
+		delete(r.tracked, key)
+	}
+}
+
+func (r *recorder) Victim(exclude any) (any, bool) {
+	for key := range r.tracked {
+		if key != exclude {
+			r.OnDelete(key)
+			return key, true
+		}
+	}
+	return nil, false
+}
+
 func TestStashMemory(t *testing.T) {
 	const debug = true
 
@@ -37,30 +104,30 @@ func TestStashMemory(t *testing.T) {
 				DebugPrefix: "[stash] ",
 			})
 		}))
-	// t.Run("Evict Least Recently Used", tests.TestEvictLeastRecentlyUsed(t,
-	// 	func(timeToLive time.Duration, maxSize int) interface {
-	// 		stash.Stasher
-	// 	} {
-	// 		return newStash(memory.Configuration{
-	// 			EvictionPolicy: stash.LeastRecentlyUsed,
-	// 			TimeToLive:     timeToLive,
-	// 			MaxSize:        maxSize,
-	// 			Debug:          debug,
-	// 			DebugPrefix:    "[stash] ",
-	// 		})
-	// 	}))
-	// t.Run("Evict Least Frequently Used", tests.TestEvictLeastFrequentlyUsed(t,
-	// 	func(timeToLive time.Duration, maxSize int) interface {
-	// 		stash.Stasher
-	// 	} {
-	// 		return newStash(memory.Configuration{
-	// 			EvictionPolicy: stash.LeastFrequentlyUsed,
-	// 			TimeToLive:     timeToLive,
-	// 			MaxSize:        maxSize,
-	// 			Debug:          debug,
-	// 			DebugPrefix:    "[stash] ",
-	// 		})
-	// 	}))
+	t.Run("Evict Least Recently Used", tests.TestEvictLeastRecentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.LeastRecentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Least Frequently Used", tests.TestEvictLeastFrequentlyUsed(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.LeastFrequentlyUsed,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
 	t.Run("Evict First In First Out", tests.TestEvictFirstInFirstOut(t,
 		func(timeToLive time.Duration, maxSize int) interface {
 			stash.Stasher
@@ -73,4 +140,595 @@ func TestStashMemory(t *testing.T) {
 				DebugPrefix:    "[stash] ",
 			})
 		}))
+	t.Run("Evict Adaptive Replacement", tests.TestEvictAdaptiveReplacement(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.AdaptiveReplacement,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Admission", func(t *testing.T) {
+		const nWarm, nReads, nFlood = 9, 20, 100
+
+		//KIM: the window holds a single item, so every item written
+		// is a candidate once the next item is written; the keys have
+		// the same length so that every item has the same size
+		ex := &stash.Example{String: "warm_000"}
+		bytes, _ := ex.MarshalBinary()
+		s := newStash(memory.Configuration{
+			EvictionPolicy: stash.LeastRecentlyUsed,
+			MaxSize:        (nWarm + 1) * len(bytes),
+			Admission:      true,
+			AdmissionRatio: 1 / float64(nWarm+1),
+			Debug:          debug,
+			DebugPrefix:    "[stash] ",
+		})
+
+		//warm items (read often) that fill the stash
+		for i := 0; i < nWarm; i++ {
+			key := fmt.Sprintf("warm_%03d", i)
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+			for j := 0; j < nReads; j++ {
+				err := s.Read(key, &stash.Example{})
+				assert.Nil(t, err)
+			}
+		}
+
+		//validate that items read once aren't admitted
+		for i := 0; i < nFlood; i++ {
+			key := fmt.Sprintf("cold_%03d", i)
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+		}
+		for i := 0; i < nFlood-1; i++ {
+			err := s.Read(fmt.Sprintf("cold_%03d", i), &stash.Example{})
+			assert.NotNil(t, err)
+		}
+		for i := 0; i < nWarm; i++ {
+			err := s.Read(fmt.Sprintf("warm_%03d", i), &stash.Example{})
+			assert.Nil(t, err)
+		}
+
+		//validate that the estimates age: once enough items have been
+		// accessed, the warm items are no longer estimated to be used
+		// more often than an item read a few times
+		// KIM: reading an item that doesn't exist is still recorded,
+		// the estimates are halved every 81920 (or so) accesses
+		for i := 0; i < 400000; i++ {
+			_ = s.Read(i, &stash.Example{})
+		}
+		_, err := s.Write("aged_000", &stash.Example{String: "aged_000"})
+		assert.Nil(t, err)
+		for j := 0; j < 3; j++ {
+			err := s.Read("aged_000", &stash.Example{})
+			assert.Nil(t, err)
+		}
+		_, err = s.Write("cold_999", &stash.Example{String: "cold_999"})
+		assert.Nil(t, err)
+		err = s.Read("aged_000", &stash.Example{})
+		assert.Nil(t, err)
+	})
+	t.Run("Evict Sieve", tests.TestEvictSecondChance(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.Sieve,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evict Clock", tests.TestEvictSecondChance(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.Clock,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Stash Sharded", tests.TestStash(t, func() stash.Stasher {
+		return newStash(memory.Configuration{Shards: 16})
+	}))
+	t.Run("Evict Size Sharded", tests.TestEvictSize(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			return newStash(memory.Configuration{
+				EvictionPolicy: stash.FirstInFirstOut,
+				TimeToLive:     timeToLive,
+				MaxSize:        maxSize,
+				Shards:         16,
+				Debug:          debug,
+				DebugPrefix:    "[stash] ",
+			})
+		}))
+	t.Run("Evictor", func(t *testing.T) {
+		//KIM: when an evictor is provided, a single shard is used;
+		// victims are chosen per shard so the func is only provided
+		// a single shard to keep the results deterministic
+		for _, c := range []struct {
+			parameter any
+			shards    int
+		}{
+			{parameter: &cheapestFirst{costs: make(map[any]int)}, shards: 4},
+			{parameter: stash.EvictorFunc(func() stash.Evictor {
+				return &cheapestFirst{costs: make(map[any]int)}
+			}), shards: 1},
+		} {
+			examples := map[string]*stash.Example{
+				"expensive": {Int: 100, String: "expensive"},
+				"cheap":     {Int: 1, String: "cheap"},
+				"new":       {Int: 50, String: "new"},
+			}
+			bytes, _ := examples["expensive"].MarshalBinary()
+			s := memory.New(c.parameter)
+			err := s.Configure(memory.Configuration{
+				EvictionPolicy: stash.FirstInFirstOut,
+				MaxSize:        2 * len(bytes),
+				Shards:         c.shards,
+			})
+			assert.Nil(t, err)
+			for _, key := range []string{"expensive", "cheap", "new"} {
+				_, err := s.Write(key, examples[key])
+				assert.Nil(t, err)
+			}
+			for _, key := range []string{"expensive", "new"} {
+				exampleRead := &stash.Example{}
+				err := s.Read(key, exampleRead)
+				assert.Nil(t, err)
+				assert.Equal(t, examples[key], exampleRead)
+			}
+			err = s.Read("cheap", &stash.Example{})
+			assert.NotNil(t, err)
+		}
+	})
+	t.Run("Evictor Reuse", func(t *testing.T) {
+		//KIM: the evictor outlives the shards, so it should track each
+		// item once regardless of how many times the stash is resharded
+		evictor := &recorder{tracked: make(map[any]int)}
+		s := memory.New(evictor)
+		err := s.Configure(memory.Configuration{})
+		assert.Nil(t, err)
+		err = s.Initialize()
+		assert.Nil(t, err)
+		for _, key := range []string{"a", "b"} {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+		}
+		err = s.Configure(memory.Configuration{TimeToLive: time.Minute})
+		assert.Nil(t, err)
+		assert.Equal(t, map[any]int{"a": 1, "b": 1}, evictor.tracked)
+		err = s.Configure(memory.Configuration{TimeToLive: time.Minute, Shards: 2})
+		assert.Nil(t, err)
+		assert.Equal(t, map[any]int{"a": 1, "b": 1}, evictor.tracked)
+		err = s.Clear()
+		assert.Nil(t, err)
+		assert.Empty(t, evictor.tracked)
+		_, err = s.Write("c", &stash.Example{String: "c"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[any]int{"c": 1}, evictor.tracked)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+		assert.Empty(t, evictor.tracked)
+	})
+	t.Run("Reconfigure Evictors", func(t *testing.T) {
+		//KIM: the evictors are only re-created if the shards or the
+		// eviction policy change so what they've learned isn't lost
+		var created int
+		s := memory.New(stash.EvictorFunc(func() stash.Evictor {
+			created++
+			return memory.NewEvictor(stash.AdaptiveReplacement)
+		}))
+		err := s.Configure(memory.Configuration{Shards: 2})
+		assert.Nil(t, err)
+		err = s.Initialize()
+		assert.Nil(t, err)
+		created = 0
+		err = s.Configure(memory.Configuration{Shards: 2, TimeToLive: time.Minute, MaxSize: 1024})
+		assert.Nil(t, err)
+		assert.Zero(t, created)
+		err = s.Configure(memory.Configuration{Shards: 4, TimeToLive: time.Minute, MaxSize: 1024})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, created)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+	})
+	t.Run("Evict Background", func(t *testing.T) {
+		//KIM: the evictor is used to observe that items were removed
+		// without accessing the stash (which would also evict them)
+		evictor := &cheapestFirst{costs: make(map[any]int)}
+		s := memory.New(evictor)
+		err := s.Configure(memory.Configuration{
+			TimeToLive:    10 * time.Millisecond,
+			EvictionRate:  5 * time.Millisecond,
+			EvictionBatch: 1,
+			Shards:        4,
+		})
+		assert.Nil(t, err)
+		err = s.Initialize()
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := s.Write(i, &stash.Example{Int: i})
+			assert.Nil(t, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+		assert.Empty(t, evictor.costs)
+	})
+	t.Run("Reconfigure", tests.TestReconfigure(t, func() stash.Stasher {
+		s := newStash(memory.Configuration{
+			EvictionPolicy: stash.FirstInFirstOut,
+		})
+		err := s.(stash.Initializer).Initialize()
+		assert.Nil(t, err)
+		return s
+	}, func(s stash.Stasher, maxSize int) error {
+		return s.(stash.Configurer).Configure(memory.Configuration{
+			EvictionPolicy: stash.FirstInFirstOut,
+			MaxSize:        maxSize,
+		})
+	}))
+	t.Run("Reconfigure Eviction Rate", func(t *testing.T) {
+		//KIM: the eviction go routine is disabled until the stash is
+		// reconfigured with an eviction rate
+		evictor := &cheapestFirst{costs: make(map[any]int)}
+		s := memory.New(evictor)
+		err := s.Configure(memory.Configuration{
+			TimeToLive: 10 * time.Millisecond,
+		})
+		assert.Nil(t, err)
+		err = s.Initialize()
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := s.Write(i, &stash.Example{Int: i})
+			assert.Nil(t, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.Len(t, evictor.costs, 3)
+		err = s.Configure(memory.Configuration{
+			TimeToLive:   10 * time.Millisecond,
+			EvictionRate: 5 * time.Millisecond,
+		})
+		assert.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+		err = s.Shutdown()
+		assert.Nil(t, err)
+		assert.Empty(t, evictor.costs)
+	})
+	t.Run("Reconfigure Shutdown", func(t *testing.T) {
+		//KIM: reconfiguring the eviction rate while shutting down
+		// (i.e. while waiting for the eviction go routine to stop)
+		// shouldn't restart (or stop twice) the eviction go routine;
+		// the go routines need to run in parallel to hit the window
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+		for i := 0; i < 2000; i++ {
+			s := memory.New()
+			err := s.Configure(memory.Configuration{
+				TimeToLive:   time.Minute,
+				EvictionRate: time.Millisecond,
+			})
+			assert.Nil(t, err)
+			err = s.Initialize()
+			assert.Nil(t, err)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				err := s.Shutdown()
+				assert.Nil(t, err)
+			}()
+			for j := 0; j < 20; j++ {
+				err = s.Configure(memory.Configuration{
+					TimeToLive:   time.Minute,
+					EvictionRate: time.Duration(j+2) * time.Millisecond,
+				})
+				assert.Nil(t, err)
+			}
+			wg.Wait()
+			err = s.Shutdown()
+			assert.Nil(t, err)
+		}
+	})
+	t.Run("Concurrent Sharded", func(t *testing.T) {
+		const nRoutines, nKeys, maxSize = 16, 100, 1024
+
+		s := newStash(memory.Configuration{
+			EvictionPolicy: stash.LeastRecentlyUsed,
+			MaxSize:        maxSize,
+			Shards:         8,
+		})
+		var wg sync.WaitGroup
+		for i := 0; i < nRoutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < nKeys; j++ {
+					key := fmt.Sprint(rand.Intn(nKeys))
+					_, err := s.Write(key, &stash.Example{Int: j})
+					assert.Nil(t, err)
+					_ = s.Read(key, &stash.Example{})
+				}
+			}()
+		}
+		wg.Wait()
+
+		//validate that the max size was enforced across shards
+		size := 0
+		for i := 0; i < nKeys; i++ {
+			example := &stash.Example{}
+			if err := s.Read(fmt.Sprint(i), example); err == nil {
+				bytes, _ := example.MarshalBinary()
+				size += len(bytes)
+			}
+		}
+		assert.LessOrEqual(t, size, maxSize)
+	})
+	t.Run("Read Expired", func(t *testing.T) {
+		//KIM: an item whose time to live has been exceeded must not
+		// be read even if it hasn't been evicted yet
+		for _, evictionPolicy := range []stash.EvictionPolicy{
+			stash.LeastRecentlyUsed,
+			stash.Sieve,
+		} {
+			s := newStash(memory.Configuration{
+				EvictionPolicy: evictionPolicy,
+				TimeToLive:     50 * time.Millisecond,
+			})
+			_, err := s.Write("expired", &stash.Example{String: "expired"})
+			assert.Nil(t, err)
+			err = s.Read("expired", &stash.Example{})
+			assert.Nil(t, err)
+			time.Sleep(100 * time.Millisecond)
+			err = s.Read("expired", &stash.Example{})
+			assert.NotNil(t, err, evictionPolicy)
+			err = s.Delete("expired")
+			assert.NotNil(t, err, evictionPolicy)
+		}
+	})
+	t.Run("Eviction Cost", func(t *testing.T) {
+		//KIM: every operation evicts an item, so if the cost of an eviction
+		// depended on the number of items, the large stash would be about
+		// a hundred times slower; the ratio allows for cache misses (a map
+		// alone is about three times slower at the large size)
+		const nSmall, nLarge, nOperations, nRounds, maxRatio = 1000, 100000, 10000, 3, 4.0
+
+		if testing.Short() {
+			t.Skip("skipping eviction cost in short mode")
+		}
+		example := &stash.Example{Int: 1}
+		bytes, _ := example.MarshalBinary()
+		cost := func(evictionPolicy stash.EvictionPolicy, nItems int) time.Duration {
+			var best time.Duration
+
+			s := memory.New()
+			err := s.Configure(memory.Configuration{
+				EvictionPolicy: evictionPolicy,
+				TimeToLive:     time.Hour,
+				MaxSize:        nItems * len(bytes),
+			})
+			assert.Nil(t, err)
+			defer s.Shutdown()
+			for i := 0; i < nItems; i++ {
+				_, err := s.Write(i, example)
+				assert.Nil(t, err)
+			}
+			key := nItems
+			for round := 0; round < nRounds; round++ {
+				start := time.Now()
+				for i := 0; i < nOperations; i, key = i+1, key+1 {
+					_, _ = s.Write(key, example)
+					_ = s.Read(key-rand.Intn(100), &stash.Example{})
+				}
+				if elapsed := time.Since(start); round == 0 || elapsed < best {
+					best = elapsed
+				}
+			}
+			return best / nOperations
+		}
+		for _, evictionPolicy := range []stash.EvictionPolicy{
+			stash.FirstInFirstOut,
+			stash.LeastRecentlyUsed,
+			stash.LeastFrequentlyUsed,
+			stash.AdaptiveReplacement,
+			stash.Sieve,
+			stash.Clock,
+		} {
+			small, large := cost(evictionPolicy, nSmall), cost(evictionPolicy, nLarge)
+			ratio := float64(large) / float64(small)
+			t.Logf("%s: %v per operation with %d items, %v with %d items (%0.2fx)",
+				evictionPolicy, small, nSmall, large, nLarge, ratio)
+			assert.Less(t, ratio, maxRatio, "cost of %s grew with the number of items", evictionPolicy)
+		}
+	})
+	t.Run("Health Check", func(t *testing.T) {
+		s := newStash(memory.Configuration{})
+		err := s.(stash.Initializer).Initialize()
+		assert.Nil(t, err)
+		tests.TestHealthCheck(t, func() stash.HealthChecker {
+			return s.(stash.HealthChecker)
+		}, func() stash.HealthChecker {
+			return newStash(memory.Configuration{}).(stash.HealthChecker)
+		})(t)
+		err = s.(stash.Shutdowner).Shutdown()
+		assert.Nil(t, err)
+		err = s.(stash.HealthChecker).Ping(context.Background())
+		assert.NotNil(t, err)
+	})
+	t.Run("Validate", func(t *testing.T) {
+		config := memory.NewConfiguration()
+		err := config.FromEnvs(map[string]string{
+			"STASH_TIME_TO_LIVE":  "5m",
+			"STASH_EVICTION_RATE": "10",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 5*time.Minute, config.TimeToLive)
+		assert.Equal(t, 10*time.Second, config.EvictionRate)
+		err = config.FromEnvs(map[string]string{
+			"STASH_MAX_SIZE":     "large",
+			"STASH_TIME_TO_LIVE": "forever",
+			"STASH_DEBUG_PREFIX": "[stash] ",
+		})
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Equal(t, stash.ConfigurationError{
+				{Field: "STASH_MAX_SIZE", Err: configurationError[0].Err},
+				{Field: "STASH_TIME_TO_LIVE", Err: configurationError[1].Err},
+			}, configurationError)
+		}
+		config = memory.NewConfiguration()
+		assert.Nil(t, config.Validate())
+		config.EvictionPolicy = "random"
+		config.AdmissionRatio = 2
+		config.Shards = -1
+		err = config.Validate()
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 3)
+			assert.Equal(t, "admission_ratio", configurationError[0].Field)
+		}
+		s := memory.New()
+		err = s.Configure(config)
+		assert.NotNil(t, err)
+		err = s.Initialize()
+		assert.NotNil(t, err)
+		err = s.Configure(map[string]string{"STASH_SHARDS": "many"})
+		assert.NotNil(t, err)
+		err = s.Configure(memory.Configuration{})
+		assert.Nil(t, err)
+	})
+	t.Run("Load", func(t *testing.T) {
+		//KIM: the file is overridden by the environment which is
+		// overridden by the flags
+		path := filepath.Join(t.TempDir(), "config.json")
+		err := os.WriteFile(path, []byte(`{"eviction_policy": "sieve",
+			"time_to_live": "1m", "max_size": 100, "shards": 2}`), 0600)
+		assert.Nil(t, err)
+		t.Setenv("TEST_STASH_MAX_SIZE", "200")
+		t.Setenv("TEST_STASH_SHARDS", "4")
+		config := memory.NewConfiguration()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		config.RegisterFlags(fs, "stash-")
+		err = fs.Parse([]string{"-stash-shards", "8", "-stash-eviction-rate", "5s", "-stash-debug=false"})
+		assert.Nil(t, err)
+		err = config.Load(path, "TEST_", fs)
+		assert.Nil(t, err)
+		assert.Equal(t, stash.Sieve, config.EvictionPolicy)
+		assert.Equal(t, time.Minute, config.TimeToLive)
+		assert.Equal(t, 200, config.MaxSize)
+		assert.Equal(t, 8, config.Shards)
+		assert.Equal(t, 5*time.Second, config.EvictionRate)
+		assert.False(t, config.Debug)
+		err = fs.Parse([]string{"-stash-max-size", "large"})
+		assert.NotNil(t, err)
+		assert.Equal(t, "the number of shards the items are split across", fs.Lookup("stash-shards").Usage)
+		err = os.WriteFile(path, []byte(`{"time_to_live": "forever", "max_size": "large",
+			"shards": "two", "admission": 1, "debug_prefix": "[stash] "}`), 0600)
+		assert.Nil(t, err)
+		config = memory.NewConfiguration()
+		err = config.FromFile(path)
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			var fields []string
+			for _, fieldError := range configurationError {
+				fields = append(fields, fieldError.Field)
+			}
+			assert.Equal(t, []string{"admission", "max_size", "shards", "time_to_live"}, fields)
+		}
+		assert.Equal(t, "[stash] ", config.DebugPrefix)
+		err = os.WriteFile(path, []byte(`{"max_sise": 100}`), 0600)
+		assert.Nil(t, err)
+		err = memory.NewConfiguration().FromFile(path)
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Equal(t, "max_sise", configurationError[0].Field)
+		}
+		err = os.WriteFile(path, []byte(`{"eviction_policy": "random"}`), 0600)
+		assert.Nil(t, err)
+		err = memory.NewConfiguration().Load(path, "TEST_", nil)
+		assert.NotNil(t, err)
+	})
+}
+
+// BenchmarkStashMemory can be used to compare the cost of each operation
+// for different numbers of items in the stash, the eviction policies don't
+// do any work that depends on the number of items, but random reads across
+// a larger stash miss the cpu cache more often
+func BenchmarkStashMemory(b *testing.B) {
+	for _, evictionPolicy := range []stash.EvictionPolicy{
+		stash.FirstInFirstOut,
+		stash.LeastRecentlyUsed,
+		stash.LeastFrequentlyUsed,
+		stash.AdaptiveReplacement,
+		stash.Sieve,
+		stash.Clock,
+	} {
+		for _, nItems := range []int{1000, 10000, 100000} {
+			b.Run(fmt.Sprintf("%s/%d", evictionPolicy, nItems), func(b *testing.B) {
+				example := &stash.Example{Int: 1}
+				bytes, _ := example.MarshalBinary()
+				s := memory.New()
+				err := s.Configure(memory.Configuration{
+					EvictionPolicy: evictionPolicy,
+					TimeToLive:     time.Hour,
+					MaxSize:        nItems * len(bytes),
+				})
+				assert.Nil(b, err)
+				for i := 0; i < nItems; i++ {
+					_, err := s.Write(i, example)
+					assert.Nil(b, err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					//KIM: every write is a new key, so each write
+					// will evict an item
+					_, _ = s.Write(nItems+i, example)
+					_ = s.Read(nItems+i-rand.Intn(nItems), &stash.Example{})
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkStashMemoryRead can be used to compare the cost of concurrent
+// reads between eviction policies that require an exclusive lock and those
+// that can read while holding a shared lock
+func BenchmarkStashMemoryRead(b *testing.B) {
+	const nItems = 1000
+
+	for _, evictionPolicy := range []stash.EvictionPolicy{
+		stash.LeastRecentlyUsed,
+		stash.Sieve,
+		stash.Clock,
+	} {
+		b.Run(string(evictionPolicy), func(b *testing.B) {
+			s := memory.New()
+			err := s.Configure(memory.Configuration{
+				EvictionPolicy: evictionPolicy,
+			})
+			assert.Nil(b, err)
+			for i := 0; i < nItems; i++ {
+				_, err := s.Write(i, &stash.Example{Int: i})
+				assert.Nil(b, err)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = s.Read(rand.Intn(nItems), &stash.Example{})
+				}
+			})
+		})
+	}
 }
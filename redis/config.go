@@ -1,57 +1,282 @@
 package redis
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"net"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	stash "github.com/antonio-alexander/go-stash"
+	errors "github.com/pkg/errors"
 	goredis "github.com/redis/go-redis/v9"
 )
 
 const (
-	defaultAddress      string        = "localhost"
-	defaultPort         string        = "6379"
-	defaultDatabase     int           = 0
-	defaultHashKey      string        = "gostash_redis"
-	defaultTimeout      time.Duration = 10 * time.Second
-	defaultEvictionRate time.Duration = time.Minute
+	defaultAddress             string        = "localhost"
+	defaultPort                string        = "6379"
+	defaultDatabase            int           = 0
+	defaultHashKey             string        = "gostash_redis"
+	defaultTimeout             time.Duration = 10 * time.Second
+	defaultEvictionRate        time.Duration = time.Minute
+	defaultStorageMode         StorageMode   = StorageModeHash
+	defaultKeyPrefix           string        = "gostash_redis:"
+	defaultEvictionBatch       int           = 1000
+	defaultPartitions          int           = 1
+	defaultInvalidationChannel string        = "gostash_invalidation"
+)
+
+// StorageMode is a typed string used to describe how items are
+// stored within redis
+type StorageMode string
+
+const (
+	//StorageModeHash will store all items as fields of a single
+	// hash (HashKey), time to live is enforced by periodically
+	// reading all of the items
+	StorageModeHash StorageMode = "hash"
+
+	//StorageModeKey will store each item as its own key (prefixed
+	// by KeyPrefix), time to live is enforced by redis
+	StorageModeKey StorageMode = "key"
 )
 
 type Configuration struct {
-	Address        string               `json:"address"`
-	Port           string               `json:"port"`
-	Password       string               `json:"password"`
-	Database       int                  `json:"database"`
-	HashKey        string               `json:"hash_key"`
-	Timeout        time.Duration        `json:"timeout"`
-	EvictionPolicy stash.EvictionPolicy `json:"eviction_policy"`
-	TimeToLive     time.Duration        `json:"time_to_live"`
-	Debug          bool                 `json:"debug"`
-	DebugPrefix    string               `json:"debug_prefix"`
-	EvictionRate   time.Duration        `json:"eviction_rate"`
+	Address               string               `json:"address" usage:"the address of the redis server"`
+	Port                  string               `json:"port" usage:"the port of the redis server"`
+	Socket                string               `json:"socket" usage:"the unix socket of the redis server (overrides the address)"`
+	URL                   string               `json:"url" usage:"the url of the redis server (overrides the address)"`
+	Addresses             []string             `json:"addresses" usage:"the addresses of the redis cluster (comma separated)"`
+	MasterName            string               `json:"master_name" usage:"the name of the sentinel master"`
+	SentinelAddresses     []string             `json:"sentinel_addresses" usage:"the addresses of the sentinels (comma separated)"`
+	SentinelPassword      string               `json:"sentinel_password" usage:"the password of the sentinels"`
+	Username              string               `json:"username" usage:"the username used to authenticate"`
+	Password              string               `json:"password" usage:"the password used to authenticate"`
+	Database              int                  `json:"database" usage:"the redis database"`
+	TLSEnabled            bool                 `json:"tls_enabled" usage:"connect to redis using tls"`
+	TLSCAFile             string               `json:"tls_ca_file" usage:"the ca certificate used to verify the server"`
+	TLSCertFile           string               `json:"tls_cert_file" usage:"the client certificate"`
+	TLSKeyFile            string               `json:"tls_key_file" usage:"the key of the client certificate"`
+	TLSServerName         string               `json:"tls_server_name" usage:"the name used to verify the server certificate"`
+	TLSInsecureSkipVerify bool                 `json:"tls_insecure_skip_verify" usage:"do not verify the server certificate"`
+	HashKey               string               `json:"hash_key" usage:"the key of the hash items are stored in (hash storage mode)"`
+	StorageMode           StorageMode          `json:"storage_mode" usage:"how items are stored (hash or key)"`
+	KeyPrefix             string               `json:"key_prefix" usage:"the prefix of the key of each item (key storage mode)"`
+	Partitions            int                  `json:"partitions" usage:"the number of hashes items are split across (hash storage mode)"`
+	Timeout               time.Duration        `json:"timeout" usage:"the timeout of each operation"`
+	ReadTimeout           time.Duration        `json:"read_timeout" usage:"the timeout of reads (overrides the timeout)"`
+	WriteTimeout          time.Duration        `json:"write_timeout" usage:"the timeout of writes (overrides the timeout)"`
+	EvictionTimeout       time.Duration        `json:"eviction_timeout" usage:"the timeout of evictions (overrides the timeout)"`
+	DialTimeout           time.Duration        `json:"dial_timeout" usage:"the timeout used to connect to redis"`
+	SocketReadTimeout     time.Duration        `json:"socket_read_timeout" usage:"the timeout of socket reads"`
+	SocketWriteTimeout    time.Duration        `json:"socket_write_timeout" usage:"the timeout of socket writes"`
+	PoolSize              int                  `json:"pool_size" usage:"the maximum number of connections"`
+	MinIdleConns          int                  `json:"min_idle_conns" usage:"the minimum number of idle connections"`
+	MaxRetries            int                  `json:"max_retries" usage:"the maximum number of retries (-1 disables)"`
+	EvictionPolicy        stash.EvictionPolicy `json:"eviction_policy" usage:"the policy used to evict items when the stash is full"`
+	TimeToLive            time.Duration        `json:"time_to_live" usage:"how long an item is kept after it was last updated (0 disables)"`
+	MaxSize               int                  `json:"max_size" usage:"the maximum size of the items in bytes (0 is unlimited)"`
+	MaxEntries            int                  `json:"max_entries" usage:"the maximum number of items (0 is unlimited)"`
+	Debug                 bool                 `json:"debug" usage:"enable debug logging"`
+	DebugPrefix           string               `json:"debug_prefix" usage:"the prefix of debug log messages"`
+	EvictionRate          time.Duration        `json:"eviction_rate" usage:"how often items are evicted in the background"`
+	EvictionBatch         int                  `json:"eviction_batch" usage:"the maximum number of items evicted at once"`
+	EvictionLeader        bool                 `json:"eviction_leader" usage:"only evict items while holding the eviction lease"`
+	EvictionLeaseDuration time.Duration        `json:"eviction_lease_duration" usage:"how long the eviction lease is held"`
+	StatsFlushRate        time.Duration        `json:"stats_flush_rate" usage:"how often buffered read statistics are flushed (0 disables)"`
+	HealthCheckRate       time.Duration        `json:"health_check_rate" usage:"how often redis is pinged in the background (0 disables)"`
+	InvalidationChannel   string               `json:"invalidation_channel" usage:"the channel used to publish invalidations"`
+	LocalCacheMaxSize     int                  `json:"local_cache_max_size" usage:"the maximum size of the client side cache in bytes (0 disables)"`
 }
 
 func NewConfiguration() *Configuration {
 	return &Configuration{
-		Address:      defaultAddress,
-		Port:         defaultPort,
-		Database:     defaultDatabase,
-		HashKey:      defaultHashKey,
-		Timeout:      defaultTimeout,
-		EvictionRate: defaultEvictionRate,
+		Address:             defaultAddress,
+		Port:                defaultPort,
+		Database:            defaultDatabase,
+		HashKey:             defaultHashKey,
+		StorageMode:         defaultStorageMode,
+		KeyPrefix:           defaultKeyPrefix,
+		Timeout:             defaultTimeout,
+		EvictionRate:        defaultEvictionRate,
+		EvictionBatch:       defaultEvictionBatch,
+		InvalidationChannel: defaultInvalidationChannel,
 	}
 }
 
 func (c *Configuration) ToRedisOptions() *goredis.Options {
-	address := c.Address
+	network, address := "tcp", c.Address
 	if c.Port != "" {
 		address = address + ":" + c.Port
 	}
+	if c.Socket != "" {
+		network, address = "unix", c.Socket
+	}
 	return &goredis.Options{
-		Addr:     address,
-		Password: c.Password,
-		DB:       c.Database,
+		Network:      network,
+		Addr:         address,
+		Username:     c.Username,
+		Password:     c.Password,
+		DB:           c.Database,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.SocketReadTimeout,
+		WriteTimeout: c.SocketWriteTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+	}
+}
+
+// ToTLSConfig will return the tls configuration used to connect to
+// redis, nil is returned if tls isn't enabled; tls is enabled if any
+// of the tls options have been provided
+func (c *Configuration) ToTLSConfig() (*tls.Config, error) {
+	if !c.TLSEnabled && c.TLSCAFile == "" && c.TLSCertFile == "" &&
+		c.TLSServerName == "" && !c.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+	if c.TLSCAFile != "" {
+		bytes, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read ca file")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bytes) {
+			return nil, errors.Errorf("no certificates found in ca file: %s", c.TLSCAFile)
+		}
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// FromURL will set the address (or socket), username, password,
+// database and whether tls is enabled using the given url, the
+// following schemes are supported:
+//
+//	redis://[username:password@]host[:port][/database]
+//	rediss://[username:password@]host[:port][/database]
+//	unix://[username:password@]path[?db=database]
+func (c *Configuration) FromURL(url string) error {
+	options, err := goredis.ParseURL(url)
+	if err != nil {
+		return err
+	}
+	switch options.Network {
+	case "unix":
+		c.Socket = options.Addr
+	default:
+		host, port, err := net.SplitHostPort(options.Addr)
+		if err != nil {
+			return err
+		}
+		c.Address, c.Port, c.Socket = host, port, ""
+	}
+	c.Username, c.Password = options.Username, options.Password
+	c.Database = options.DB
+	if options.TLSConfig != nil {
+		c.TLSEnabled = true
+	}
+	return nil
+}
+
+// ToRedisClusterOptions will return the options used to connect to
+// a cluster using the configured addresses as seeds
+func (c *Configuration) ToRedisClusterOptions() *goredis.ClusterOptions {
+	return &goredis.ClusterOptions{
+		Addrs:        c.Addresses,
+		Username:     c.Username,
+		Password:     c.Password,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.SocketReadTimeout,
+		WriteTimeout: c.SocketWriteTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+	}
+}
+
+// ToRedisFailoverOptions will return the options used to connect to
+// the master (with the configured name) using the configured sentinels
+func (c *Configuration) ToRedisFailoverOptions() *goredis.FailoverOptions {
+	return &goredis.FailoverOptions{
+		MasterName:       c.MasterName,
+		SentinelAddrs:    c.SentinelAddresses,
+		SentinelPassword: c.SentinelPassword,
+		Username:         c.Username,
+		Password:         c.Password,
+		DB:               c.Database,
+		DialTimeout:      c.DialTimeout,
+		ReadTimeout:      c.SocketReadTimeout,
+		WriteTimeout:     c.SocketWriteTimeout,
+		PoolSize:         c.PoolSize,
+		MinIdleConns:     c.MinIdleConns,
+		MaxRetries:       c.MaxRetries,
+	}
+}
+
+// FromEnviron will set the configuration using the environmental
+// variables of the process (see FromEnvs), if a prefix is provided, only
+// the variables with the prefix are used (e.g. with the prefix APP_,
+// APP_REDIS_ADDRESS is used)
+func (c *Configuration) FromEnviron(prefix string) error {
+	return c.FromEnvs(stash.Environ(prefix))
+}
+
+// FromFile will set the configuration using the given json file, fields
+// that aren't present keep their values; durations can be provided as
+// strings (e.g. "30s") or as a number of nanoseconds
+func (c *Configuration) FromFile(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return stash.UnmarshalConfiguration(bytes, c)
+}
+
+// RegisterFlags will register a flag for each field of the configuration
+// onto the given flag set (e.g. -max-size), each flag is prefixed with
+// the given prefix
+func (c *Configuration) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	stash.RegisterFlags(fs, prefix, c)
+}
+
+// Load will set the configuration using the following sources, each
+// source takes precedence over the sources before it:
+//
+//  1. the current values (e.g. the defaults)
+//  2. the json file at the given path (if not empty)
+//  3. the environmental variables of the process with the given prefix
+//  4. the flags (registered using RegisterFlags) that were set on the
+//     given flag set (if not nil)
+//
+// the configuration is validated once every source has been applied
+func (c *Configuration) Load(path, prefix string, fs *flag.FlagSet) error {
+	if path != "" {
+		if err := c.FromFile(path); err != nil {
+			return err
+		}
+	}
+	if err := c.FromEnviron(prefix); err != nil {
+		return err
 	}
+	if fs != nil {
+		stash.ApplyFlags(fs, c)
+	}
+	return c.Validate()
 }
 
 func (c *Configuration) Default() {
@@ -62,12 +287,25 @@ func (c *Configuration) Default() {
 	c.Port = defaultPort
 	c.Database = defaultDatabase
 	c.HashKey = defaultHashKey
+	c.StorageMode = defaultStorageMode
+	c.KeyPrefix = defaultKeyPrefix
+	c.Partitions = defaultPartitions
 	c.Timeout = defaultTimeout
 	c.EvictionRate = defaultEvictionRate
+	c.EvictionBatch = defaultEvictionBatch
+	c.InvalidationChannel = defaultInvalidationChannel
 }
 
-func (c *Configuration) FromEnvs(envs map[string]string) {
+// FromEnvs will set the configuration using the given environmental
+// variables, an error is returned for each value that can't be parsed;
+// durations can be provided as seconds (e.g. 30) or as a duration
+// string (e.g. 30s)
+func (c *Configuration) FromEnvs(envs map[string]string) error {
+	var errs stash.ConfigurationError
+
 	for key, value := range envs {
+		var err error
+
 		if value == "" {
 			continue
 		}
@@ -76,27 +314,241 @@ func (c *Configuration) FromEnvs(envs map[string]string) {
 			c.Address = value
 		case "REDIS_PORT":
 			c.Port = value
+		case "REDIS_SOCKET":
+			c.Socket = value
+		case "REDIS_URL":
+			c.URL = value
+		case "REDIS_ADDRESSES":
+			c.Addresses = strings.Split(value, ",")
+		case "REDIS_MASTER_NAME":
+			c.MasterName = value
+		case "REDIS_SENTINEL_ADDRESSES":
+			c.SentinelAddresses = strings.Split(value, ",")
+		case "REDIS_SENTINEL_PASSWORD":
+			c.SentinelPassword = value
+		case "REDIS_PARTITIONS":
+			c.Partitions, err = strconv.Atoi(value)
+		case "REDIS_USERNAME":
+			c.Username = value
 		case "REDIS_PASSWORD":
 			c.Password = value
 		case "REDIS_DATABASE":
-			c.Database, _ = strconv.Atoi(value)
+			c.Database, err = strconv.Atoi(value)
+		case "REDIS_TLS_ENABLED":
+			c.TLSEnabled, err = strconv.ParseBool(value)
+		case "REDIS_TLS_CA_FILE":
+			c.TLSCAFile = value
+		case "REDIS_TLS_CERT_FILE":
+			c.TLSCertFile = value
+		case "REDIS_TLS_KEY_FILE":
+			c.TLSKeyFile = value
+		case "REDIS_TLS_SERVER_NAME":
+			c.TLSServerName = value
+		case "REDIS_TLS_INSECURE_SKIP_VERIFY":
+			c.TLSInsecureSkipVerify, err = strconv.ParseBool(value)
 		case "REDIS_HASH_KEY":
 			c.HashKey = value
+		case "REDIS_STORAGE_MODE":
+			c.StorageMode = StorageMode(value)
+		case "REDIS_KEY_PREFIX":
+			c.KeyPrefix = value
 		case "REDIS_TIMEOUT":
-			t, _ := strconv.Atoi(value)
-			c.EvictionRate = time.Second * time.Duration(t)
+			c.Timeout, err = stash.ParseDuration(value)
+		case "REDIS_READ_TIMEOUT":
+			c.ReadTimeout, err = stash.ParseDuration(value)
+		case "REDIS_WRITE_TIMEOUT":
+			c.WriteTimeout, err = stash.ParseDuration(value)
+		case "REDIS_EVICTION_TIMEOUT":
+			c.EvictionTimeout, err = stash.ParseDuration(value)
+		case "REDIS_DIAL_TIMEOUT":
+			c.DialTimeout, err = stash.ParseDuration(value)
+		case "REDIS_SOCKET_READ_TIMEOUT":
+			c.SocketReadTimeout, err = stash.ParseDuration(value)
+		case "REDIS_SOCKET_WRITE_TIMEOUT":
+			c.SocketWriteTimeout, err = stash.ParseDuration(value)
+		case "REDIS_POOL_SIZE":
+			c.PoolSize, err = strconv.Atoi(value)
+		case "REDIS_MIN_IDLE_CONNS":
+			c.MinIdleConns, err = strconv.Atoi(value)
+		case "REDIS_MAX_RETRIES":
+			c.MaxRetries, err = strconv.Atoi(value)
 		case "STASH_EVICTION_RATE":
-			t, _ := strconv.Atoi(value)
-			c.EvictionRate = time.Second * time.Duration(t)
+			c.EvictionRate, err = stash.ParseDuration(value)
+		case "REDIS_STATS_FLUSH_RATE":
+			c.StatsFlushRate, err = stash.ParseDuration(value)
+		case "REDIS_HEALTH_CHECK_RATE":
+			c.HealthCheckRate, err = stash.ParseDuration(value)
+		case "REDIS_LOCAL_CACHE_MAX_SIZE":
+			c.LocalCacheMaxSize, err = strconv.Atoi(value)
+		case "REDIS_INVALIDATION_CHANNEL":
+			c.InvalidationChannel = value
+		case "REDIS_EVICTION_LEADER":
+			c.EvictionLeader, err = strconv.ParseBool(value)
+		case "REDIS_EVICTION_LEASE_DURATION":
+			c.EvictionLeaseDuration, err = stash.ParseDuration(value)
+		case "STASH_EVICTION_BATCH":
+			c.EvictionBatch, err = strconv.Atoi(value)
+		case "STASH_MAX_SIZE":
+			c.MaxSize, err = strconv.Atoi(value)
+		case "STASH_MAX_ENTRIES":
+			c.MaxEntries, err = strconv.Atoi(value)
 		case "STASH_EVICTION_POLICY":
 			c.EvictionPolicy = stash.EvictionPolicy(value)
 		case "STASH_TIME_TO_LIVE":
-			t, _ := strconv.Atoi(value)
-			c.TimeToLive = time.Second * time.Duration(t)
+			c.TimeToLive, err = stash.ParseDuration(value)
 		case "STASH_DEBUG_ENABLED":
-			c.Debug, _ = strconv.ParseBool(value)
+			c.Debug, err = strconv.ParseBool(value)
 		case "STASH_DEBUG_PREFIX":
 			c.DebugPrefix = value
 		}
+		if err != nil {
+			errs.Add(key, err)
+		}
+	}
+	return errs.Err()
+}
+
+// Validate will return an error describing each invalid field, zero
+// values are valid unless noted otherwise (e.g. a max size of zero is
+// unlimited); the files used by tls aren't read until the stash is
+// initialized
+func (c *Configuration) Validate() error {
+	var errs stash.ConfigurationError
+
+	if c.URL != "" {
+		if _, err := goredis.ParseURL(c.URL); err != nil {
+			errs.Add("url", err)
+		}
+	}
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			errs.Add("port", errors.Errorf("invalid port: %q", c.Port))
+		}
+	}
+	if c.MasterName != "" && len(c.SentinelAddresses) == 0 {
+		errs.Add("sentinel_addresses", errors.New("required when a master name is configured"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs.Add("tls_key_file", errors.New("the cert file and key file must be configured together"))
+	}
+	switch c.StorageMode {
+	default:
+		errs.Add("storage_mode", errors.Errorf("unknown storage mode: %q", c.StorageMode))
+	case "", StorageModeHash:
+	case StorageModeKey:
+		//KIM: clearing the stash deletes every key with the prefix
+		if c.KeyPrefix == "" {
+			errs.Add("key_prefix", errors.New("required when the storage mode is key"))
+		}
+	}
+	switch c.EvictionPolicy {
+	default:
+		errs.Add("eviction_policy", errors.Errorf("unknown eviction policy: %q", c.EvictionPolicy))
+	case stash.AdaptiveReplacement:
+		errs.Add("eviction_policy", errors.Errorf("eviction policy not supported: %q", c.EvictionPolicy))
+	case "", stash.LeastRecentlyUsed, stash.LeastFrequentlyUsed, stash.FirstInFirstOut,
+		stash.Sieve, stash.Clock:
+	}
+	if c.Timeout <= 0 {
+		errs.Add("timeout", errors.New("must be positive"))
+	}
+	for field, value := range map[string]time.Duration{
+		"read_timeout":            c.ReadTimeout,
+		"write_timeout":           c.WriteTimeout,
+		"eviction_timeout":        c.EvictionTimeout,
+		"dial_timeout":            c.DialTimeout,
+		"time_to_live":            c.TimeToLive,
+		"eviction_rate":           c.EvictionRate,
+		"eviction_lease_duration": c.EvictionLeaseDuration,
+		"stats_flush_rate":        c.StatsFlushRate,
+		"health_check_rate":       c.HealthCheckRate,
+	} {
+		if value < 0 {
+			errs.Add(field, errors.New("must not be negative"))
+		}
+	}
+	//KIM: go-redis uses -1 to disable socket timeouts (and retries) and
+	// -2 to disable socket deadlines
+	for field, value := range map[string]time.Duration{
+		"socket_read_timeout":  c.SocketReadTimeout,
+		"socket_write_timeout": c.SocketWriteTimeout,
+	} {
+		if value < -2 {
+			errs.Add(field, errors.New("must be at least -2"))
+		}
+	}
+	if c.MaxRetries < -1 {
+		errs.Add("max_retries", errors.New("must be at least -1"))
+	}
+	for field, value := range map[string]int{
+		"database":             c.Database,
+		"partitions":           c.Partitions,
+		"pool_size":            c.PoolSize,
+		"min_idle_conns":       c.MinIdleConns,
+		"max_size":             c.MaxSize,
+		"max_entries":          c.MaxEntries,
+		"eviction_batch":       c.EvictionBatch,
+		"local_cache_max_size": c.LocalCacheMaxSize,
+	} {
+		if value < 0 {
+			errs.Add(field, errors.New("must not be negative"))
+		}
+	}
+	if c.LocalCacheMaxSize > 0 && c.MasterName == "" && len(c.Addresses) > 0 {
+		errs.Add("local_cache_max_size", errors.New("client side caching isn't supported with a cluster"))
+	}
+	//KIM: reads served by the local cache only update the read
+	// statistics (used by these eviction policies) if they're buffered
+	switch c.EvictionPolicy {
+	case stash.LeastRecentlyUsed, stash.LeastFrequentlyUsed, stash.Sieve, stash.Clock:
+		if c.LocalCacheMaxSize > 0 && c.StatsFlushRate <= 0 {
+			errs.Add("stats_flush_rate", errors.Errorf("required when client side caching is used with eviction policy: %q", c.EvictionPolicy))
+		}
+	}
+	return errs.Err()
+}
+
+// reconfigurable will return an error describing each field that differs
+// from the given configuration and can't be changed while the stash is
+// initialized; these fields are used to connect to redis or determine
+// where items are stored, so changing them requires a shutdown
+func (c *Configuration) reconfigurable(config *Configuration) error {
+	var errs stash.ConfigurationError
+
+	for field, changed := range map[string]bool{
+		"address":                  c.Address != config.Address,
+		"port":                     c.Port != config.Port,
+		"socket":                   c.Socket != config.Socket,
+		"url":                      c.URL != config.URL,
+		"addresses":                strings.Join(c.Addresses, ",") != strings.Join(config.Addresses, ","),
+		"master_name":              c.MasterName != config.MasterName,
+		"sentinel_addresses":       strings.Join(c.SentinelAddresses, ",") != strings.Join(config.SentinelAddresses, ","),
+		"sentinel_password":        c.SentinelPassword != config.SentinelPassword,
+		"username":                 c.Username != config.Username,
+		"password":                 c.Password != config.Password,
+		"database":                 c.Database != config.Database,
+		"tls_enabled":              c.TLSEnabled != config.TLSEnabled,
+		"tls_ca_file":              c.TLSCAFile != config.TLSCAFile,
+		"tls_cert_file":            c.TLSCertFile != config.TLSCertFile,
+		"tls_key_file":             c.TLSKeyFile != config.TLSKeyFile,
+		"tls_server_name":          c.TLSServerName != config.TLSServerName,
+		"tls_insecure_skip_verify": c.TLSInsecureSkipVerify != config.TLSInsecureSkipVerify,
+		"hash_key":                 c.HashKey != config.HashKey,
+		"storage_mode":             c.StorageMode != config.StorageMode,
+		"key_prefix":               c.KeyPrefix != config.KeyPrefix,
+		"partitions":               c.Partitions != config.Partitions,
+		"dial_timeout":             c.DialTimeout != config.DialTimeout,
+		"socket_read_timeout":      c.SocketReadTimeout != config.SocketReadTimeout,
+		"socket_write_timeout":     c.SocketWriteTimeout != config.SocketWriteTimeout,
+		"pool_size":                c.PoolSize != config.PoolSize,
+		"min_idle_conns":           c.MinIdleConns != config.MinIdleConns,
+		"max_retries":              c.MaxRetries != config.MaxRetries,
+		"invalidation_channel":     c.InvalidationChannel != config.InvalidationChannel,
+		"local_cache_max_size":     c.LocalCacheMaxSize != config.LocalCacheMaxSize,
+	} {
+		if changed {
+			errs.Add(field, errors.New("can't be changed while initialized"))
+		}
 	}
+	return errs.Err()
 }
@@ -1,6 +1,12 @@
 package redis
 
-import errors "github.com/pkg/errors"
+import (
+	"context"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	redis "github.com/redis/go-redis/v9"
+)
 
 func parseKey(key interface{}) (string, error) {
 	switch key := key.(type) {
@@ -10,3 +16,46 @@ func parseKey(key interface{}) (string, error) {
 		return key, nil
 	}
 }
+
+// escapePattern will escape the characters of the given string that
+// have a special meaning within a pattern (e.g. SCAN MATCH)
+func escapePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`,
+		`[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// newClient will create a client using the given configuration, the
+// url (if provided) takes precedence over the address, credentials
+// and database; a failover client is created if a master name is
+// provided and a cluster client if addresses are provided. If provided,
+// onConnect is called whenever a connection is established
+func newClient(config Configuration, onConnect func(context.Context, *redis.Conn) error) (redis.UniversalClient, error) {
+	if config.URL != "" {
+		if err := config.FromURL(config.URL); err != nil {
+			return nil, errors.Wrap(err, "unable to parse url")
+		}
+	}
+	tlsConfig, err := config.ToTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	default:
+		options := config.ToRedisOptions()
+		if tlsConfig != nil && tlsConfig.ServerName == "" && config.Socket == "" {
+			//KIM: the certificate of the server is verified using
+			// its address unless a server name is provided
+			tlsConfig.ServerName = config.Address
+		}
+		options.TLSConfig, options.OnConnect = tlsConfig, onConnect
+		return redis.NewClient(options), nil
+	case config.MasterName != "":
+		options := config.ToRedisFailoverOptions()
+		options.TLSConfig, options.OnConnect = tlsConfig, onConnect
+		return redis.NewFailoverClient(options), nil
+	case len(config.Addresses) > 0:
+		options := config.ToRedisClusterOptions()
+		options.TLSConfig, options.OnConnect = tlsConfig, onConnect
+		return redis.NewClusterClient(options), nil
+	}
+}
@@ -0,0 +1,336 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	stash "github.com/antonio-alexander/go-stash"
+
+	"github.com/google/uuid"
+	errors "github.com/pkg/errors"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// invalidation is the message published to the invalidation channel
+// when items are mutated, the origin is used to ignore invalidations
+// published by the same process
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Clear  bool     `json:"clear,omitempty"`
+}
+
+type stashInvalidator struct {
+	sync.RWMutex
+	sync.WaitGroup
+	redis.UniversalClient
+	pubsub      *redis.PubSub
+	logger      stash.Logger
+	local       stash.Stasher
+	origin      string
+	status      stash.Status
+	statusLock  sync.Mutex
+	config      *Configuration
+	initialized bool
+	configured  bool
+}
+
+// NewInvalidator can be used to create a stash that wraps a local stash
+// (e.g. memory) provided using SetParameters; items are written to and
+// read from the local stash, but writes, deletes and clears are published
+// to a redis channel (Invalidation Channel) so that the local stashes of
+// other processes drop their (stale) copies
+// KIM: only string keys can be published
+func NewInvalidator(parameters ...any) interface {
+	stash.Stasher
+	stash.Configurer
+	stash.Initializer
+	stash.Shutdowner
+	stash.Parameterizer
+	stash.HealthChecker
+} {
+	s := &stashInvalidator{}
+	s.SetParameters(parameters...)
+	return s
+}
+
+func (s *stashInvalidator) printf(format string, a ...any) {
+	if s.logger != nil && s.config != nil && s.config.Debug {
+		s.logger.Printf(s.config.DebugPrefix+format, a...)
+	}
+}
+
+// publish will publish the given invalidation to the invalidation
+// channel
+func (s *stashInvalidator) publish(message invalidation) error {
+	message.Origin = s.origin
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(s.config.WriteTimeout))
+	defer cancel()
+	return s.Publish(ctx, s.config.InvalidationChannel, bytes).Err()
+}
+
+// invalidate will drop the items of the given invalidation from the
+// local stash, invalidations published by this process are ignored
+func (s *stashInvalidator) invalidate(local stash.Stasher, payload string) {
+	var message invalidation
+
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		s.printf("error while unmarshalling invalidation: %s\n", err)
+		return
+	}
+	if message.Origin == s.origin {
+		return
+	}
+	if message.Clear {
+		if err := local.Clear(); err != nil {
+			s.printf("error while clearing: %s\n", err)
+		}
+		s.printf("invalidated all items\n")
+		return
+	}
+	for _, key := range message.Keys {
+		//KIM: the item may not exist within the local stash
+		_ = local.Delete(key)
+		s.printf("invalidated key: %s\n", key)
+	}
+}
+
+// timeout will return the given timeout or the default timeout if
+// the given timeout isn't set
+func (s *stashInvalidator) timeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return s.config.Timeout
+}
+
+// launchSubscribe will start a go routine that will drop items from
+// the local stash as invalidations are received, the go routine stops
+// when the subscription is closed
+func (s *stashInvalidator) launchSubscribe() {
+	local, messages := s.local, s.pubsub.ChannelWithSubscriptions()
+	started := make(chan struct{})
+	s.Add(1)
+	go func() {
+		defer s.Done()
+
+		close(started)
+		for message := range messages {
+			switch message := message.(type) {
+			case *redis.Subscription:
+				//KIM: the channel is re-subscribed after the connection
+				// is re-established, invalidations published while
+				// disconnected were missed, so the local stash is cleared
+				if message.Kind != "subscribe" {
+					continue
+				}
+				if err := local.Clear(); err != nil {
+					s.printf("error while clearing: %s\n", err)
+				}
+				s.printf("re-subscribed to %s, invalidated all items\n", message.Channel)
+			case *redis.Message:
+				s.invalidate(local, message.Payload)
+			}
+		}
+	}()
+	<-started
+}
+
+func (s *stashInvalidator) Configure(items ...any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var config *Configuration
+
+	for _, item := range items {
+		switch item := item.(type) {
+		case *Configuration:
+			config = item
+		case Configuration:
+			config = &item
+		case map[string]string:
+			config = &Configuration{}
+			config.Default()
+			if err := config.FromEnvs(item); err != nil {
+				return err
+			}
+		}
+	}
+	if config != nil {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		s.config = config
+		s.configured = true
+	}
+
+	return nil
+}
+
+func (s *stashInvalidator) SetParameters(items ...any) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range items {
+		switch item := item.(type) {
+		case stash.Logger:
+			s.logger = item
+		case stash.Stasher:
+			s.local = item
+		}
+	}
+}
+
+func (s *stashInvalidator) Initialize() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.configured {
+		return errors.New("not configured")
+	}
+	if s.initialized {
+		return errors.New("already initialized")
+	}
+	if s.local == nil {
+		return errors.New("local stash not provided")
+	}
+	if s.config.InvalidationChannel == "" {
+		return errors.New("invalidation channel not configured")
+	}
+	client, err := newClient(*s.config, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	//KIM: the confirmation of the subscription is received so that
+	// subsequent confirmations can be treated as re-subscriptions
+	pubsub := client.Subscribe(ctx, s.config.InvalidationChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		client.Close()
+		return err
+	}
+	s.UniversalClient, s.pubsub = client, pubsub
+	s.origin = uuid.Must(uuid.NewRandom()).String()
+	s.launchSubscribe()
+	s.initialized = true
+	return nil
+}
+
+func (s *stashInvalidator) Shutdown() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.initialized {
+		return nil
+	}
+	if err := s.pubsub.Close(); err != nil {
+		s.printf("error while closing subscription: %s", err)
+	}
+	s.Wait()
+	if err := s.Close(); err != nil {
+		s.printf("error while closing client: %s", err)
+	}
+	s.initialized, s.configured = false, false
+	return nil
+}
+
+// Ping can be used to check whether the stash is usable, an
+// error is returned if the stash hasn't been initialized or
+// redis can't be reached
+func (s *stashInvalidator) Ping(ctx context.Context) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	tStart := time.Now()
+	err := errors.New("not initialized")
+	if s.initialized {
+		err = s.UniversalClient.Ping(ctx).Err()
+	}
+	s.statusLock.Lock()
+	s.status = stash.NewStatus(tStart, err)
+	s.statusLock.Unlock()
+	return err
+}
+
+// Status returns the result of the last ping
+func (s *stashInvalidator) Status() stash.Status {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	return s.status
+}
+
+func (s *stashInvalidator) Write(key any, itemToCache stash.Cacheable) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		return false, errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := s.local.Write(key, itemToCache)
+	if err != nil {
+		return false, err
+	}
+	if err := s.publish(invalidation{Keys: []string{field}}); err != nil {
+		return replaced, errors.Wrap(err, "unable to publish invalidation")
+	}
+	return replaced, nil
+}
+
+func (s *stashInvalidator) Read(key any, v stash.Cacheable) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	return s.local.Read(key, v)
+}
+
+// Delete will remove the item from the local stash, the invalidation
+// is published even if the item doesn't exist within the local stash
+func (s *stashInvalidator) Delete(key any) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	errDelete := s.local.Delete(key)
+	if err := s.publish(invalidation{Keys: []string{field}}); err != nil {
+		return errors.Wrap(err, "unable to publish invalidation")
+	}
+	return errDelete
+}
+
+func (s *stashInvalidator) Clear() error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		return errors.New("not initialized")
+	}
+	if err := s.local.Clear(); err != nil {
+		return err
+	}
+	if err := s.publish(invalidation{Clear: true}); err != nil {
+		return errors.Wrap(err, "unable to publish invalidation")
+	}
+	return nil
+}
@@ -2,27 +2,54 @@ package redis
 
 import (
 	"context"
-	"encoding"
-	"encoding/json"
-	"sort"
+	"encoding/base64"
+	"hash/crc32"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	stash "github.com/antonio-alexander/go-stash"
 
+	"github.com/google/uuid"
 	errors "github.com/pkg/errors"
 	redis "github.com/redis/go-redis/v9"
 )
 
+// readStats are the read statistics of an item that have yet to
+// be flushed
+type readStats struct {
+	lastRead int64
+	nReads   int
+}
+
+// PoolStats are the statistics of the connection pool of the
+// redis client (e.g. hits, misses, timeouts and connections)
+type PoolStats = redis.PoolStats
+
 type stashRedis struct {
 	sync.RWMutex
 	sync.WaitGroup
-	*redis.Client
-	logger      stash.Logger
-	stopper     chan struct{}
-	config      *Configuration
-	initialized bool
-	configured  bool
+	redis.UniversalClient
+	tickers        sync.WaitGroup
+	logger         stash.Logger
+	evictor        stash.Evictor
+	evictorLock    sync.Mutex
+	stats          map[string]*readStats
+	statsLock      sync.Mutex
+	cache          *localCache
+	tracking       *redis.PubSub
+	trackingClient redis.UniversalClient
+	owner          string
+	leader         int32
+	stopper        chan struct{}
+	reload         chan struct{}
+	status         stash.Status
+	statusLock     sync.Mutex
+	config         atomic.Pointer[Configuration]
+	initialized    bool
+	configured     bool
 }
 
 func New(parameters ...any) interface {
@@ -31,6 +58,9 @@ func New(parameters ...any) interface {
 	stash.Initializer
 	stash.Shutdowner
 	stash.Parameterizer
+	stash.HealthChecker
+	PoolStats() *PoolStats
+	Leader() bool
 } {
 
 	s := &stashRedis{}
@@ -39,151 +69,468 @@ func New(parameters ...any) interface {
 }
 
 func (s *stashRedis) printf(format string, a ...any) {
-	if s.logger != nil && s.config != nil && s.config.Debug {
-		s.logger.Printf(s.config.DebugPrefix+format, a...)
+	if config := s.config.Load(); s.logger != nil && config != nil && config.Debug {
+		s.logger.Printf(config.DebugPrefix+format, a...)
+	}
+}
+
+// timeout will return the given timeout or the default timeout if
+// the given timeout isn't set
+func (s *stashRedis) timeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return s.config.Load().Timeout
+}
+
+// notify will call the given function with the evictor (if
+// configured) while holding the evictor lock
+// KIM: the evictor is only notified of operations performed by
+// this process, it doesn't know about items written by others; the
+// evictor can be set (SetParameters) while the eviction go routine runs
+func (s *stashRedis) notify(fx func(evictor stash.Evictor)) {
+	s.evictorLock.Lock()
+	defer s.evictorLock.Unlock()
+	if s.evictor == nil {
+		return
+	}
+	fx(s.evictor)
+}
+
+// custom returns true if an evictor has been provided
+func (s *stashRedis) custom() bool {
+	s.evictorLock.Lock()
+	defer s.evictorLock.Unlock()
+	return s.evictor != nil
+}
+
+// tag will return the hash tag of the given partition, every key
+// used by a partition contains its tag so that in a cluster, they
+// belong to the same slot (and can be used by the same script)
+func (s *stashRedis) tag(partition int) string {
+	config := s.config.Load()
+	if config.Partitions <= 1 {
+		return config.HashKey
+	}
+	return config.HashKey + ":" + strconv.Itoa(partition)
+}
+
+// partition will return the partition of the given field
+func (s *stashRedis) partition(field string) int {
+	partitions := s.config.Load().Partitions
+	if partitions <= 1 {
+		return 0
+	}
+	return int(crc32.ChecksumIEEE([]byte(field)) % uint32(partitions))
+}
+
+// partitions will return every partition
+func (s *stashRedis) partitions() []int {
+	partitions := []int{0}
+	for i := 1; i < s.config.Load().Partitions; i++ {
+		partitions = append(partitions, i)
+	}
+	return partitions
+}
+
+// keys will return the keys of the given partition provided to every
+// script, the order must match the order documented in scripts.go
+func (s *stashRedis) keys(partition int) []string {
+	//KIM: the indexes are named such that they don't share the
+	// default key prefix used to store items
+	tag := s.tag(partition)
+	index := "{" + tag + "}.index."
+	return []string{
+		tag,
+		index + indexCreated,
+		index + indexRead,
+		index + indexReads,
+		index + indexExpiry,
+		index + indexSizes,
+		index + indexBytes,
+		index + indexHand,
+		index + indexStats,
+		index + indexVisited,
+	}
+}
+
+// limit will return the portion of the given limit enforced by
+// each partition
+func (s *stashRedis) limit(limit int) int {
+	partitions := s.config.Load().Partitions
+	if partitions <= 1 || limit <= 0 {
+		return limit
+	}
+	return (limit + partitions - 1) / partitions
+}
+
+// scripts will return every script used by the stash
+func scripts() []*redis.Script {
+	return []*redis.Script{scriptWrite, scriptRead, scriptStats,
+		scriptRemove, scriptEvict, scriptLease, scriptRelease}
+}
+
+// prefix will return the prefix of the key of every item of the given
+// partition (key storage mode)
+func (s *stashRedis) prefix(partition int) string {
+	return s.config.Load().KeyPrefix + "{" + s.tag(partition) + "}"
+}
+
+// args will return the arguments of the given partition provided to
+// every script followed by the given arguments
+func (s *stashRedis) args(partition int, args ...any) []any {
+	config := s.config.Load()
+	return append([]any{string(config.StorageMode), s.prefix(partition)}, args...)
+}
+
+// remove will remove the item with the given field and its indexes,
+// it returns true if the item existed
+func (s *stashRedis) remove(ctx context.Context, field string) (bool, error) {
+	partition := s.partition(field)
+	n, err := scriptRemove.Run(ctx, s.UniversalClient, s.keys(partition),
+		s.args(partition, field)...).Int64()
+	s.dropLocal(field)
+	if err != nil {
+		return false, err
 	}
+	return n > 0, nil
 }
 
-func (s *stashRedis) evict() {
-	var cachedItems []*stash.CachedItem
+// evict will remove any items whose time to live has been exceeded and
+// then remove items until the number of items and the size of the stash
+// are below the configured maximums; the exclude key is never evicted so
+// that the item most recently accessed is always available
+// KIM: only the partition of the exclude key is evicted since it's the
+// only partition that could have changed, if there's no exclude key, all
+// partitions are evicted
+func (s *stashRedis) evict(exclude any) {
+	if exclude == nil {
+		for _, partition := range s.partitions() {
+			s.evictPartition(partition, nil)
+		}
+		return
+	}
+	field, err := parseKey(exclude)
+	if err != nil {
+		return
+	}
+	s.evictPartition(s.partition(field), exclude)
+}
+
+// evictPartition will evict items from the given partition, the max
+// entries and max size are divided between the partitions
+// KIM: victims are chosen by a script using the index of the eviction
+// policy unless an evictor has been provided, in which case the evictor
+// is asked for victims while the script reports that the stash is full
+func (s *stashRedis) evictPartition(partition int, exclude any) {
+	var excludeField string
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	if exclude != nil {
+		excludeField, _ = parseKey(exclude)
+	}
+	config := s.config.Load()
+	evictionPolicy := string(config.EvictionPolicy)
+	switch {
+	case s.custom():
+		evictionPolicy = ""
+	case evictionPolicy == "":
+		evictionPolicy = string(stash.FirstInFirstOut)
+	}
+	maxEntries, maxSize := s.limit(config.MaxEntries), s.limit(config.MaxSize)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(config.EvictionTimeout))
 	defer cancel()
-	items, err := s.HGetAll(ctx, s.config.HashKey).Result()
+	result, err := scriptEvict.Run(ctx, s.UniversalClient, s.keys(partition),
+		s.args(partition, evictionPolicy, time.Now().UnixNano(), maxEntries,
+			maxSize, excludeField, config.EvictionBatch)...).Slice()
 	if err != nil {
 		s.printf("error while evicting: %s\n", err.Error())
 		return
 	}
-	for _, item := range items {
-		var cachedItem stash.CachedItem
+	for i := 1; i+1 < len(result); i += 2 {
+		key, reason := result[i].(string), result[i+1].(string)
+		s.dropLocal(key)
+		s.notify(func(evictor stash.Evictor) { evictor.OnDelete(key) })
+		s.printf("evicted key: %v, %s\n", key, reason)
+	}
+	if full, _ := result[0].(int64); full == 0 {
+		return
+	}
+	for {
+		var key any
+		var ok bool
 
-		if err := json.Unmarshal([]byte(item), &cachedItem); err != nil {
+		s.notify(func(evictor stash.Evictor) { key, ok = evictor.Victim(exclude) })
+		if !ok {
+			return
+		}
+		field, err := parseKey(key)
+		if err != nil {
 			s.printf("error while evicting: %s\n", err.Error())
 			return
 		}
-		cachedItems = append(cachedItems, &cachedItem)
-	}
-	evictionPolicy := s.config.EvictionPolicy
-	if evictionPolicy != "" {
-		s.printf("eviction Policy: %s\n", evictionPolicy)
-	}
-	switch evictionPolicy {
-	case stash.FirstInFirstOut:
-		sort.Sort(stash.ByFirstCreated(cachedItems))
-		for _, cacheItem := range cachedItems {
-			s.printf("key: %v, %v\n", cacheItem.Key, cacheItem.LastRead)
+		if _, err := s.remove(ctx, field); err != nil {
+			s.printf("error while evicting: %s\n", err.Error())
+			return
 		}
-	case stash.LeastRecentlyUsed:
-		sort.Sort(stash.ByLastRead(cachedItems))
-		for _, cacheItem := range cachedItems {
-			s.printf("key: %v, %v\n", cacheItem.Key, cacheItem.LastRead)
+		s.printf("evicted key: %v, max size exceeded\n", key)
+		result, err := scriptEvict.Run(ctx, s.UniversalClient, s.keys(partition),
+			s.args(partition, "", time.Now().UnixNano(), maxEntries, maxSize,
+				excludeField, 0)...).Slice()
+		if err != nil {
+			s.printf("error while evicting: %s\n", err.Error())
+			return
 		}
-	case stash.LeastFrequentlyUsed:
-		sort.Sort(stash.ByTimesRead(cachedItems))
-		for _, cacheItem := range cachedItems {
-			s.printf("key: %v, %d\n", cacheItem.Key, cacheItem.NTimesRead)
+		if full, _ := result[0].(int64); full == 0 {
+			return
 		}
 	}
-	//ensure we don't evict the only data that's in the
-	// stash even if we're above the max limit because
-	// there's only a single item in the stash
-	if len(cachedItems) <= 1 {
-		return
+}
+
+// leaseKey returns the key of the eviction lease
+func (s *stashRedis) leaseKey() string {
+	return "{" + s.config.Load().HashKey + "}.lease"
+}
+
+// lease will acquire (or renew) the eviction lease, it returns true if
+// this process is the leader (and should sweep)
+func (s *stashRedis) lease() bool {
+	config := s.config.Load()
+	duration := config.EvictionLeaseDuration
+	if duration <= 0 {
+		duration = 3 * config.EvictionRate
 	}
-	tNow := time.Now()
-	for _, cacheItem := range cachedItems {
-		switch {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(config.EvictionTimeout))
+	defer cancel()
+	n, err := scriptLease.Run(ctx, s.UniversalClient, []string{s.leaseKey()},
+		s.owner, duration.Milliseconds()).Int64()
+	if err != nil {
+		s.printf("error while acquiring eviction lease: %s\n", err)
+	}
+	leader := int32(0)
+	if err == nil && n == 1 {
+		leader = 1
+	}
+	if previous := atomic.SwapInt32(&s.leader, leader); previous != leader {
+		switch leader {
+		case 1:
+			s.printf("acquired eviction lease\n")
 		default:
-			if s.config.TimeToLive == 0 {
-				return
-			}
-		case s.config.TimeToLive > 0 && tNow.Sub(time.Unix(0, cacheItem.LastUpdated)) > s.config.TimeToLive:
-			ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
-			defer cancel()
-			field, err := parseKey(cacheItem.Key)
-			if err != nil {
-				s.printf("error while evicting: %s\n", err.Error())
-				return
-			}
-			if err := s.HDel(ctx, s.config.HashKey, field).Err(); err != nil {
-				s.printf("error while evicting: %s\n", err.Error())
-				return
-			}
-			s.printf("evicted key: %v, ttl exceeded\n", cacheItem.Key)
+			s.printf("lost eviction lease\n")
 		}
 	}
+	return leader == 1
+}
+
+// release will release the eviction lease (if held) so that another
+// process can become the leader without waiting for it to expire
+func (s *stashRedis) release() {
+	if atomic.SwapInt32(&s.leader, 0) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(s.config.Load().EvictionTimeout))
+	defer cancel()
+	if err := scriptRelease.Run(ctx, s.UniversalClient, []string{s.leaseKey()},
+		s.owner).Err(); err != nil {
+		s.printf("error while releasing eviction lease: %s\n", err)
+		return
+	}
+	s.printf("released eviction lease\n")
+}
+
+// Leader returns true if this process holds the eviction lease (and
+// sweeps), it's always false if leader election isn't enabled
+func (s *stashRedis) Leader() bool {
+	return atomic.LoadInt32(&s.leader) == 1
 }
 
 func (s *stashRedis) launchEvict() {
-	if s.config.EvictionRate <= 0 {
+	config := s.config.Load()
+	if config.StorageMode == StorageModeKey {
+		s.printf("eviction go routine disabled, time to live enforced by redis\n")
+		return
+	}
+	if config.EvictionRate <= 0 {
 		s.printf("eviction go routine disabled\n")
 		return
 	}
 	started := make(chan struct{})
-	s.Add(1)
-	go func() {
-		defer s.Done()
+	s.tickers.Add(1)
+	go func(reload <-chan struct{}) {
+		defer s.tickers.Done()
 
-		tEvict := time.NewTicker(s.config.EvictionRate)
+		tEvict := time.NewTicker(config.EvictionRate)
 		defer tEvict.Stop()
 		close(started)
-		s.evict()
+		sweep := func() {
+			if config.EvictionLeader && !s.lease() {
+				return
+			}
+			s.evict(nil)
+		}
+		sweep()
 		for {
 			select {
 			case <-s.stopper:
 				return
+			case <-reload:
+				return
 			case <-tEvict.C:
-				s.evict()
+				sweep()
 			}
 		}
-	}()
+	}(s.reload)
 	<-started
 }
 
-func (s *stashRedis) write(key, item any) error {
-	var bytes []byte
+// bufferRead will record that the item with the given field was
+// read, it returns the number of reads that have yet to be flushed
+func (s *stashRedis) bufferRead(field string, lastRead int64) int {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
 
-	field, err := parseKey(key)
-	if err != nil {
-		return err
+	stats, ok := s.stats[field]
+	if !ok {
+		stats = &readStats{}
+		s.stats[field] = stats
 	}
-	switch v := item.(type) {
-	default:
-		//return error
-		return errors.Errorf("unsupported item to write: %T", v)
-	case []byte:
-		bytes = v
-	case string:
-		bytes = []byte(v)
-	case encoding.BinaryMarshaler:
-		byts, err := v.MarshalBinary()
-		if err != nil {
-			return err
-		}
-		bytes = byts
+	stats.lastRead = lastRead
+	stats.nReads++
+	return stats.nReads
+}
+
+// flushStats will update the read statistics of every item that has
+// been read since the last flush, if the statistics of a partition
+// can't be flushed, they're buffered again so they're flushed with
+// the next flush
+func (s *stashRedis) flushStats() {
+	s.statsLock.Lock()
+	stats := s.stats
+	s.stats = make(map[string]*readStats)
+	s.statsLock.Unlock()
+
+	if len(stats) == 0 {
+		return
+	}
+	fields := make(map[int][]string)
+	for field := range stats {
+		partition := s.partition(field)
+		fields[partition] = append(fields[partition], field)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(s.config.Load().WriteTimeout))
 	defer cancel()
-	return s.HSet(ctx, s.config.HashKey, field, string(bytes)).Err()
+	flushed := 0
+	for partition, fields := range fields {
+		args := make([]any, 0, 3*len(fields))
+		for _, field := range fields {
+			args = append(args, field, stats[field].lastRead, stats[field].nReads)
+		}
+		if err := scriptStats.Run(ctx, s.UniversalClient, s.keys(partition),
+			s.args(partition, args...)...).Err(); err != nil {
+			s.printf("error while flushing read statistics: %s\n", err.Error())
+			s.restoreStats(fields, stats)
+			continue
+		}
+		//KIM: the local copies have the read statistics from before the
+		// flush, they're dropped so the next read includes the flushed reads
+		for _, field := range fields {
+			s.dropLocal(field)
+		}
+		flushed += len(fields)
+	}
+	s.printf("flushed read statistics of %d items\n", flushed)
 }
 
-func (s *stashRedis) read(key any) (*stash.CachedItem, error) {
-	var cachedItem stash.CachedItem
-
-	field, err := parseKey(key)
-	if err != nil {
-		return nil, err
+// restoreStats will merge the read statistics of the given fields that
+// couldn't be flushed with the read statistics buffered since
+func (s *stashRedis) restoreStats(fields []string, stats map[string]*readStats) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	for _, field := range fields {
+		buffered, ok := s.stats[field]
+		if !ok {
+			s.stats[field] = stats[field]
+			continue
+		}
+		buffered.nReads += stats[field].nReads
+		if stats[field].lastRead > buffered.lastRead {
+			buffered.lastRead = stats[field].lastRead
+		}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
-	defer cancel()
-	value, err := s.HGet(ctx, s.config.HashKey, field).Result()
-	if err != nil {
-		return nil, err
+}
+
+// launchFlush will start a go routine that will periodically flush
+// the buffered read statistics, if disabled, the read statistics are
+// updated on every read
+func (s *stashRedis) launchFlush() {
+	config := s.config.Load()
+	if config.StatsFlushRate <= 0 {
+		return
 	}
-	if err := cachedItem.UnmarshalBinary([]byte(value)); err != nil {
-		return nil, err
+	started := make(chan struct{})
+	s.tickers.Add(1)
+	go func(reload <-chan struct{}) {
+		defer s.tickers.Done()
+
+		tFlush := time.NewTicker(config.StatsFlushRate)
+		defer tFlush.Stop()
+		close(started)
+		for {
+			select {
+			case <-s.stopper:
+				return
+			case <-reload:
+				return
+			case <-tFlush.C:
+				s.flushStats()
+			}
+		}
+	}(s.reload)
+	<-started
+}
+
+// ping will ping redis and update the status
+func (s *stashRedis) ping(ctx context.Context) error {
+	tStart := time.Now()
+	err := s.UniversalClient.Ping(ctx).Err()
+	s.statusLock.Lock()
+	s.status = stash.NewStatus(tStart, err)
+	s.statusLock.Unlock()
+	return err
+}
+
+// launchHealthCheck will start a go routine that will periodically
+// ping redis so that the status reflects whether redis can be reached
+// without waiting for the next operation
+func (s *stashRedis) launchHealthCheck() {
+	config := s.config.Load()
+	if config.HealthCheckRate <= 0 {
+		return
 	}
-	return &cachedItem, nil
+	started := make(chan struct{})
+	s.tickers.Add(1)
+	go func(reload <-chan struct{}) {
+		defer s.tickers.Done()
+
+		tHealthCheck := time.NewTicker(config.HealthCheckRate)
+		defer tHealthCheck.Stop()
+		close(started)
+		for {
+			select {
+			case <-s.stopper:
+				return
+			case <-reload:
+				return
+			case <-tHealthCheck.C:
+				ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+				if err := s.ping(ctx); err != nil {
+					s.printf("error while pinging: %s\n", err)
+				}
+				cancel()
+			}
+		}
+	}(s.reload)
+	<-started
 }
 
 func (s *stashRedis) Configure(items ...any) error {
@@ -201,17 +548,56 @@ func (s *stashRedis) Configure(items ...any) error {
 		case map[string]string:
 			config = &Configuration{}
 			config.Default()
-			config.FromEnvs(item)
+			if err := config.FromEnvs(item); err != nil {
+				return err
+			}
 		}
 	}
 	if config != nil {
-		s.config = config
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		if s.initialized {
+			if err := s.config.Load().reconfigurable(config); err != nil {
+				return err
+			}
+		}
+		switch {
+		case s.initialized:
+			s.reconfigure(config)
+		default:
+			s.config.Store(config)
+		}
 		s.configured = true
 	}
 
 	return nil
 }
 
+// reconfigure will apply the given configuration to an initialized
+// stash, the go routines are restarted (so that they use the new rates)
+// and the stash is evicted so that the new limits are met immediately
+// KIM: the configuration is swapped atomically since it's read without
+// the lock (e.g. by the go routines and the eviction that follows each
+// operation), each of which loads it once and uses that copy; the time
+// to live of existing items isn't changed, the new time to live is used
+// as items are written
+func (s *stashRedis) reconfigure(config *Configuration) {
+	close(s.reload)
+	s.tickers.Wait()
+	s.flushStats()
+	if s.config.Load().EvictionLeader && !config.EvictionLeader {
+		s.release()
+	}
+	s.config.Store(config)
+	s.reload = make(chan struct{})
+	s.launchEvict()
+	s.launchFlush()
+	s.launchHealthCheck()
+	s.evict(nil)
+	s.printf("reconfigured\n")
+}
+
 func (s *stashRedis) SetParameters(items ...any) {
 	s.Lock()
 	defer s.Unlock()
@@ -220,6 +606,10 @@ func (s *stashRedis) SetParameters(items ...any) {
 		switch item := item.(type) {
 		case stash.Logger:
 			s.logger = item
+		case stash.Evictor:
+			s.evictorLock.Lock()
+			s.evictor = item
+			s.evictorLock.Unlock()
 		}
 	}
 }
@@ -234,13 +624,48 @@ func (s *stashRedis) Initialize() error {
 	if s.initialized {
 		return errors.New("already initialized")
 	}
-	s.Client = redis.NewClient(s.config.ToRedisOptions())
-	s.stopper = make(chan struct{})
+	config := s.config.Load()
+	client, err := newClient(*config, nil)
+	if err != nil {
+		return err
+	}
+	s.UniversalClient = client
+	//KIM: scripts are run using EVALSHA and will be loaded (using
+	// EVAL) if they're not found, so failing to load them now
+	// isn't fatal
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	for _, script := range scripts() {
+		if err := script.Load(ctx, s.UniversalClient).Err(); err != nil {
+			s.printf("error while loading script: %s\n", err)
+		}
+	}
+	s.stats = make(map[string]*readStats)
+	s.stopper, s.reload = make(chan struct{}), make(chan struct{})
+	s.owner = uuid.Must(uuid.NewRandom()).String()
+	if err := s.launchTracking(); err != nil {
+		s.Close()
+		return err
+	}
 	s.launchEvict()
+	s.launchFlush()
+	s.launchHealthCheck()
 	s.initialized = true
 	return nil
 }
 
+// PoolStats will return the statistics of the connection pool, nil
+// is returned if the stash hasn't been initialized
+func (s *stashRedis) PoolStats() *PoolStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		return nil
+	}
+	return s.UniversalClient.PoolStats()
+}
+
 func (s *stashRedis) Shutdown() error {
 	s.Lock()
 	defer s.Unlock()
@@ -249,54 +674,146 @@ func (s *stashRedis) Shutdown() error {
 		return nil
 	}
 	close(s.stopper)
+	if s.tracking != nil {
+		if err := s.tracking.Close(); err != nil {
+			s.printf("error while closing tracking: %s", err)
+		}
+	}
 	s.Wait()
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
-	defer cancel()
-	if err := s.Client.Shutdown(ctx).Err(); err != nil {
-		s.printf("error while shutting down client: %s", err)
+	s.tickers.Wait()
+	s.flushStats()
+	s.release()
+	if s.trackingClient != nil {
+		if err := s.trackingClient.Close(); err != nil {
+			s.printf("error while closing tracking client: %s", err)
+		}
+	}
+	s.cache, s.tracking, s.trackingClient = nil, nil, nil
+	if err := s.Close(); err != nil {
+		s.printf("error while closing client: %s", err)
 	}
 	s.initialized, s.configured = false, false
 	return nil
 }
 
+// Ping can be used to check whether the stash is usable, an
+// error is returned if the stash hasn't been initialized or
+// redis can't be reached
+func (s *stashRedis) Ping(ctx context.Context) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.initialized {
+		err := errors.New("not initialized")
+		s.statusLock.Lock()
+		s.status = stash.NewStatus(time.Now(), err)
+		s.statusLock.Unlock()
+		return err
+	}
+	return s.ping(ctx)
+}
+
+// Status returns the result of the last ping
+func (s *stashRedis) Status() stash.Status {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	return s.status
+}
+
 func (s *stashRedis) Write(key any, itemToCache stash.Cacheable) (bool, error) {
+	var cachedItem stash.CachedItem
+	var expiry int64
+
 	s.RLock()
-	defer s.evict()
+	defer s.evict(key)
 	defer s.RUnlock()
 
-	cachedItem, err := s.read(key)
-	if err != nil && err != redis.Nil {
+	field, err := parseKey(key)
+	if err != nil {
 		return false, err
 	}
-	switch {
-	default: //found
-		if err := stash.UpdateCacheItem(cachedItem, itemToCache); err != nil {
-			return false, err
-		}
-		if err := s.write(key, cachedItem); err != nil {
-			return false, err
-		}
+	bytes, err := itemToCache.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+	config := s.config.Load()
+	tNow := time.Now().UnixNano()
+	if config.TimeToLive > 0 {
+		expiry = tNow + config.TimeToLive.Nanoseconds()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(config.WriteTimeout))
+	defer cancel()
+	partition := s.partition(field)
+	result, err := scriptWrite.Run(ctx, s.UniversalClient, s.keys(partition),
+		s.args(partition, field, base64.StdEncoding.EncodeToString(bytes),
+			len(bytes), tNow, config.TimeToLive.Milliseconds(), expiry)...).Slice()
+	s.dropLocal(field)
+	if err != nil {
+		return false, err
+	}
+	replaced, _ := result[0].(int64)
+	value, _ := result[1].(string)
+	if err := cachedItem.UnmarshalBinary([]byte(value)); err != nil {
+		return false, err
+	}
+	s.notify(func(evictor stash.Evictor) { evictor.OnWrite(&cachedItem) })
+	if replaced == 1 {
 		s.printf("updated key: %v\n", key)
 		return true, nil
-	case err == redis.Nil: //not found
-		cachedItem, err := stash.CreateCacheItem(key, itemToCache)
-		if err != nil {
-			return false, err
-		}
-		if err := s.write(key, cachedItem); err != nil {
-			return false, err
-		}
-		s.printf("created key: %v\n", key)
-		return false, nil
 	}
+	s.printf("created key: %v\n", key)
+	return false, nil
 }
 
 func (s *stashRedis) Read(key any, v stash.Cacheable) error {
+	var cachedItem stash.CachedItem
+	var epoch uint64
+	var local bool
+
 	s.RLock()
-	defer s.evict()
+	defer func() {
+		//KIM: items read from the local cache aren't evicted
+		// since it would require a round trip
+		if !local {
+			s.evict(key)
+		}
+	}()
 	defer s.RUnlock()
 
-	cachedItem, err := s.read(key)
+	field, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	config := s.config.Load()
+	tNow := time.Now().UnixNano()
+	partition := s.partition(field)
+	if localItem, ok := s.readLocal(partition, field); ok {
+		local = true
+		if config.StatsFlushRate > 0 {
+			localItem.LastRead = tNow
+			localItem.NTimesRead += s.bufferRead(field, tNow)
+		}
+		if err := v.UnmarshalBinary(localItem.Bytes); err != nil {
+			return err
+		}
+		s.notify(func(evictor stash.Evictor) { evictor.OnRead(localItem) })
+		s.printf("read key (local): %v\n", key)
+		return nil
+	}
+	if s.cache != nil {
+		epoch = s.cache.epoch(partition)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(config.ReadTimeout))
+	defer cancel()
+	//KIM: if the read statistics are buffered, they're read
+	// but not updated by the script
+	nReads := 1
+	if config.StatsFlushRate > 0 {
+		nReads = 0
+	}
+	result, err := scriptRead.Run(ctx, s.UniversalClient, s.keys(partition),
+		s.args(partition, field, tNow, nReads)...).Slice()
 	if err != nil {
 		switch err {
 		default:
@@ -305,36 +822,50 @@ func (s *stashRedis) Read(key any, v stash.Cacheable) error {
 			return errors.Errorf("value for %s not found", key)
 		}
 	}
-	cachedItem.LastRead = time.Now().UnixNano()
-	cachedItem.NTimesRead++
-	if err := v.UnmarshalBinary(cachedItem.Bytes); err != nil {
+	value, _ := result[0].(string)
+	if err := cachedItem.UnmarshalBinary([]byte(value)); err != nil {
 		return err
 	}
-	if err := s.write(key, cachedItem); err != nil {
+	lastRead, _ := result[1].(string)
+	nTimesRead, _ := result[2].(int64)
+	cachedItem.LastRead, _ = strconv.ParseInt(lastRead, 10, 64)
+	cachedItem.NTimesRead = int(nTimesRead)
+	//KIM: the local copy is stored with the flushed read statistics,
+	// the buffered reads are added whenever it's read
+	if s.cache != nil {
+		s.cache.store(partition, epoch, field, &cachedItem)
+	}
+	if nReads == 0 {
+		cachedItem.LastRead = tNow
+		cachedItem.NTimesRead += s.bufferRead(field, tNow)
+	}
+	if err := v.UnmarshalBinary(cachedItem.Bytes); err != nil {
 		return err
 	}
+	s.notify(func(evictor stash.Evictor) { evictor.OnRead(&cachedItem) })
 	s.printf("read key: %v\n", key)
 	return nil
 }
 
 func (s *stashRedis) Delete(key any) error {
 	s.RLock()
-	defer s.evict()
+	defer s.evict(nil)
 	defer s.RUnlock()
 
 	field, err := parseKey(key)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(s.config.Load().WriteTimeout))
 	defer cancel()
-	result, err := s.HDel(ctx, s.config.HashKey, field).Result()
+	removed, err := s.remove(ctx, field)
 	if err != nil {
 		return err
 	}
-	if result == 0 {
+	if !removed {
 		return errors.Errorf("value for %s not found", key)
 	}
+	s.notify(func(evictor stash.Evictor) { evictor.OnDelete(key) })
 	s.printf("deleted key: %v\n", key)
 	return nil
 }
@@ -343,18 +874,80 @@ func (s *stashRedis) Clear() error {
 	s.Lock()
 	defer s.Unlock()
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	config := s.config.Load()
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
 	defer cancel()
-	keys, err := s.HKeys(ctx, s.config.HashKey).Result()
-	if err != nil {
-		return err
-	}
-	for _, key := range keys {
-		if _, err := s.HDel(ctx, s.config.HashKey,
-			key).Result(); err != nil {
+	for _, partition := range s.partitions() {
+		keys := s.keys(partition)
+		switch config.StorageMode {
+		case StorageModeKey:
+			if err := s.clearKeys(ctx, partition); err != nil {
+				return err
+			}
+		default:
+			fields, err := s.HKeys(ctx, keys[0]).Result()
+			if err != nil {
+				return err
+			}
+			for _, field := range fields {
+				if _, err := s.HDel(ctx, keys[0],
+					field).Result(); err != nil {
+					return err
+				}
+				s.notify(func(evictor stash.Evictor) { evictor.OnDelete(field) })
+			}
+		}
+		if err := s.Del(ctx, keys[1:]...).Err(); err != nil {
 			return err
 		}
+		if s.cache != nil {
+			s.cache.invalidate(partition, "")
+		}
 	}
-
 	return nil
 }
+
+// clearKeys will delete every item of the given partition, if
+// connected to a cluster, the keys of every master are deleted
+// KIM: the match includes the hash tag of the partition so that keys
+// that only share the configured prefix are never deleted
+func (s *stashRedis) clearKeys(ctx context.Context, partition int) error {
+	prefix := s.prefix(partition)
+	if cluster, ok := s.UniversalClient.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return s.scanDelete(ctx, client, prefix)
+		})
+	}
+	return s.scanDelete(ctx, s.UniversalClient, prefix)
+}
+
+// scanDelete will delete every key with the given prefix
+// KIM: SCAN is used rather than KEYS so redis isn't blocked while
+// iterating over a large number of keys, the keys are deleted one
+// at a time (pipelined) since they may belong to different slots
+func (s *stashRedis) scanDelete(ctx context.Context, client redis.Cmdable, prefix string) error {
+	var cursor uint64
+
+	match := escapePattern(prefix) + "*"
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, 1000).Result()
+		if err != nil {
+			return err
+		}
+		if _, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			field := strings.TrimPrefix(key, prefix)
+			s.notify(func(evictor stash.Evictor) { evictor.OnDelete(field) })
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
@@ -1,22 +1,32 @@
 package redis_test
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/antonio-alexander/go-stash"
 	"github.com/antonio-alexander/go-stash/internal"
+	"github.com/antonio-alexander/go-stash/memory"
 	"github.com/antonio-alexander/go-stash/redis"
+	"github.com/antonio-alexander/go-stash/redis/redistest"
 	"github.com/antonio-alexander/go-stash/tests"
 
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	configuration = redis.NewConfiguration()
 	envs          = make(map[string]string)
+	server        *redistest.Server
 )
 
 func init() {
@@ -28,6 +38,82 @@ func init() {
 	configuration.Debug = true
 }
 
+// TestMain will start an in-process redis server unless the address
+// of a redis server is provided (REDIS_ADDRESS)
+func TestMain(m *testing.M) {
+	if _, ok := envs["REDIS_ADDRESS"]; ok {
+		if err := configuration.FromEnvs(envs); err != nil {
+			fmt.Printf("unable to configure: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(m.Run())
+	}
+	var err error
+	if server, err = redistest.NewServer(); err != nil {
+		fmt.Printf("unable to start server: %s\n", err)
+		os.Exit(1)
+	}
+	configuration.Address, configuration.Port, _ = net.SplitHostPort(server.Addr())
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// newConfiguration returns the default configuration using the redis
+// server being tested
+func newConfiguration() *redis.Configuration {
+	config := redis.NewConfiguration()
+	config.Address, config.Port = configuration.Address, configuration.Port
+	config.Username, config.Password = configuration.Username, configuration.Password
+	config.Database = configuration.Database
+	return config
+}
+
+// newestFirst is an evictor that evicts the item that was written
+// most recently first
+type newestFirst struct {
+	keys []any
+}
+
+func (n *newestFirst) OnWrite(cachedItem *stash.CachedItem) {
+	n.OnDelete(cachedItem.Key)
+	n.keys = append(n.keys, cachedItem.Key)
+}
+
+func (n *newestFirst) OnRead(*stash.CachedItem) {}
+
+func (n *newestFirst) OnDelete(key any) {
+	for i := range n.keys {
+		if n.keys[i] == key {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *newestFirst) Victim(exclude any) (any, bool) {
+	for i := len(n.keys) - 1; i >= 0; i-- {
+		if key := n.keys[i]; key != exclude {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// timesRead is an evictor that records the most number of times
+// an item has been read
+type timesRead struct {
+	newestFirst
+	max int
+}
+
+func (r *timesRead) OnRead(cachedItem *stash.CachedItem) {
+	if cachedItem.NTimesRead > r.max {
+		r.max = cachedItem.NTimesRead
+	}
+}
+
 func TestStashRedis(t *testing.T) {
 	newStash := func(config *redis.Configuration) stash.Stasher {
 		logger := internal.NewLogger()
@@ -37,6 +123,10 @@ func TestStashRedis(t *testing.T) {
 		assert.Nil(t, err)
 		err = r.Initialize()
 		assert.Nil(t, err)
+		//KIM: the stashes share the same keys, so items written
+		// by previous tests would count towards the max size
+		err = r.Clear()
+		assert.Nil(t, err)
 		return r
 	}
 	t.Run("Stash", tests.TestStash(t, func() stash.Stasher {
@@ -46,9 +136,10 @@ func TestStashRedis(t *testing.T) {
 		func(timeToLive time.Duration, maxSize int) interface {
 			stash.Stasher
 		} {
-			config := redis.NewConfiguration()
+			config := newConfiguration()
 			config.EvictionPolicy = stash.LeastRecentlyUsed
 			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
 			config.DebugPrefix = "[stash] "
 			return newStash(config)
 		}))
@@ -56,20 +147,852 @@ func TestStashRedis(t *testing.T) {
 		func(timeToLive time.Duration, maxSize int) interface {
 			stash.Stasher
 		} {
-			config := redis.NewConfiguration()
+			config := newConfiguration()
 			config.EvictionPolicy = stash.LeastFrequentlyUsed
 			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
+			config.DebugPrefix = "[stash] "
+			return newStash(config)
+		}))
+	t.Run("Stash Key", tests.TestStash(t, func() stash.Stasher {
+		config := newConfiguration()
+		config.StorageMode = redis.StorageModeKey
+		config.Debug = true
+		return newStash(config)
+	}))
+	t.Run("Evict Size", tests.TestEvictSize(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			config := newConfiguration()
+			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
+			config.DebugPrefix = "[stash] "
+			return newStash(config)
+		}))
+	t.Run("Evict First In First Out", tests.TestEvictFirstInFirstOut(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			config := newConfiguration()
+			config.EvictionPolicy = stash.FirstInFirstOut
+			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
+			config.DebugPrefix = "[stash] "
+			return newStash(config)
+		}))
+	t.Run("Evict Sieve", tests.TestEvictSecondChance(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			config := newConfiguration()
+			config.EvictionPolicy = stash.Sieve
+			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
+			config.DebugPrefix = "[stash] "
+			return newStash(config)
+		}))
+	t.Run("Evict Size Key", tests.TestEvictSize(t,
+		func(timeToLive time.Duration, maxSize int) interface {
+			stash.Stasher
+		} {
+			config := newConfiguration()
+			config.StorageMode = redis.StorageModeKey
+			config.TimeToLive = timeToLive
+			config.MaxSize = maxSize
 			config.DebugPrefix = "[stash] "
 			return newStash(config)
 		}))
-	// t.Run("Evict First In First Out", tests.TestEvictFirstInFirstOut(t,
-	// 	func(timeToLive time.Duration, maxSize int) interface {
-	// 		stash.Stasher
-	// 	} {
-	// 		config := redis.NewConfiguration()
-	// 		config.EvictionPolicy = stash.FirstInFirstOut
-	// 		config.TimeToLive = timeToLive
-	// 		config.DebugPrefix = "[stash] "
-	// 		return newStash(config)
-	// 	}))
+	t.Run("Evict Max Entries", func(t *testing.T) {
+		config := newConfiguration()
+		config.EvictionPolicy = stash.LeastRecentlyUsed
+		config.MaxEntries = 2
+		s := newStash(config)
+		keys := []string{"entry_1", "entry_2", "entry_3"}
+		for _, key := range keys {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+			err = s.Read(keys[0], &stash.Example{})
+			assert.Nil(t, err)
+		}
+		err := s.Read(keys[1], &stash.Example{})
+		assert.NotNil(t, err)
+		for _, key := range []string{keys[0], keys[2]} {
+			err := s.Read(key, &stash.Example{})
+			assert.Nil(t, err)
+		}
+	})
+	t.Run("Evict Sieve Hand", func(t *testing.T) {
+		//KIM: the hand resumes from the item after the last victim
+		// so the item that was passed (and is no longer visited) is
+		// kept rather than restarting from the oldest item
+		config := newConfiguration()
+		config.EvictionPolicy = stash.Sieve
+		config.MaxEntries = 3
+		s := newStash(config)
+		keys := []string{"hand_a", "hand_b", "hand_c", "hand_d", "hand_e"}
+		for _, key := range keys[:3] {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+			time.Sleep(time.Millisecond)
+		}
+		err := s.Read(keys[0], &stash.Example{})
+		assert.Nil(t, err)
+		for _, key := range keys[3:] {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+			time.Sleep(time.Millisecond)
+		}
+		for _, key := range []string{keys[1], keys[2]} {
+			err := s.Read(key, &stash.Example{})
+			assert.NotNil(t, err, key)
+		}
+		for _, key := range []string{keys[0], keys[3], keys[4]} {
+			err := s.Read(key, &stash.Example{})
+			assert.Nil(t, err, key)
+		}
+	})
+	t.Run("Evictor", func(t *testing.T) {
+		config := newConfiguration()
+		config.EvictionPolicy = stash.FirstInFirstOut
+		config.MaxEntries = 2
+		s := newStash(config)
+		s.(stash.Parameterizer).SetParameters(&newestFirst{})
+		keys := []string{"evictor_1", "evictor_2", "evictor_3"}
+		for _, key := range keys {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+		}
+		err := s.Read(keys[1], &stash.Example{})
+		assert.NotNil(t, err)
+		for _, key := range []string{keys[0], keys[2]} {
+			err := s.Read(key, &stash.Example{})
+			assert.Nil(t, err)
+		}
+	})
+	t.Run("Concurrent Read", func(t *testing.T) {
+		const nRoutines, nReads = 8, 25
+
+		//KIM: reads are atomic, so no reads should be lost even
+		// if the item is read concurrently
+		evictor := &timesRead{}
+		s := newStash(newConfiguration())
+		s.(stash.Parameterizer).SetParameters(evictor)
+		_, err := s.Write("concurrent", &stash.Example{String: "concurrent"})
+		assert.Nil(t, err)
+		var wg sync.WaitGroup
+		for i := 0; i < nRoutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < nReads; j++ {
+					err := s.Read("concurrent", &stash.Example{})
+					assert.Nil(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, nRoutines*nReads, evictor.max)
+	})
+	t.Run("Buffered Read Statistics", func(t *testing.T) {
+		evictor := &timesRead{}
+		config := newConfiguration()
+		//KIM: the flush rate is long enough that the reads aren't
+		// interrupted by a flush (even when run with -race)
+		config.StatsFlushRate = 100 * time.Millisecond
+		s := newStash(config)
+		s.(stash.Parameterizer).SetParameters(evictor)
+		_, err := s.Write("buffered", &stash.Example{String: "buffered"})
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			err := s.Read("buffered", &stash.Example{})
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 3, evictor.max)
+
+		//validate that the read statistics were flushed
+		time.Sleep(250 * time.Millisecond)
+		err = s.Read("buffered", &stash.Example{})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, evictor.max)
+	})
+	t.Run("Buffered Read Statistics Error", func(t *testing.T) {
+		//KIM: the index of sizes is replaced with a string so that
+		// flushing the read statistics fails (but reading doesn't)
+		evictor := &timesRead{}
+		config := newConfiguration()
+		config.StatsFlushRate = 100 * time.Millisecond
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		s.(stash.Parameterizer).SetParameters(evictor)
+		client := goredis.NewClient(&goredis.Options{
+			Addr:     net.JoinHostPort(config.Address, config.Port),
+			Username: config.Username,
+			Password: config.Password,
+			DB:       config.Database,
+		})
+		defer client.Close()
+		ctx := context.Background()
+		sizes := "{" + config.HashKey + "}.index.sizes"
+		_, err := s.Write("buffered_error", &stash.Example{String: "buffered_error"})
+		assert.Nil(t, err)
+		values, err := client.HGetAll(ctx, sizes).Result()
+		assert.Nil(t, err)
+		err = client.Set(ctx, sizes, "corrupt", 0).Err()
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			err := s.Read("buffered_error", &stash.Example{})
+			assert.Nil(t, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+		err = client.Del(ctx, sizes).Err()
+		assert.Nil(t, err)
+		err = client.HSet(ctx, sizes, values).Err()
+		assert.Nil(t, err)
+
+		//validate that the read statistics were flushed once the
+		// error was resolved
+		time.Sleep(250 * time.Millisecond)
+		err = s.Read("buffered_error", &stash.Example{})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, evictor.max)
+	})
+	t.Run("Stash Partitioned", tests.TestStash(t, func() stash.Stasher {
+		config := newConfiguration()
+		config.Partitions = 4
+		config.Debug = true
+		return newStash(config)
+	}))
+	t.Run("Stash Partitioned Key", tests.TestStash(t, func() stash.Stasher {
+		config := newConfiguration()
+		config.StorageMode = redis.StorageModeKey
+		config.Partitions = 4
+		config.Debug = true
+		return newStash(config)
+	}))
+	t.Run("Evict Max Entries Partitioned", func(t *testing.T) {
+		const nItems, maxEntries = 64, 8
+
+		//KIM: the max entries is divided between partitions, so each
+		// partition will hold at most 2 items
+		config := newConfiguration()
+		config.Partitions = 4
+		config.MaxEntries = maxEntries
+		s := newStash(config)
+		for i := 0; i < nItems; i++ {
+			_, err := s.Write(fmt.Sprint(i), &stash.Example{Int: i})
+			assert.Nil(t, err)
+		}
+		nFound := 0
+		for i := 0; i < nItems; i++ {
+			if err := s.Read(fmt.Sprint(i), &stash.Example{}); err == nil {
+				nFound++
+			}
+		}
+		assert.Greater(t, nFound, 0)
+		assert.LessOrEqual(t, nFound, maxEntries)
+		err := s.Clear()
+		assert.Nil(t, err)
+		for i := 0; i < nItems; i++ {
+			err := s.Read(fmt.Sprint(i), &stash.Example{})
+			assert.NotNil(t, err)
+		}
+	})
+	t.Run("Stash Cluster", func(t *testing.T) {
+		//KIM: the in-process server reports itself as a cluster with
+		// a single node
+		addresses, ok := envs["REDIS_ADDRESSES"]
+		if !ok && server == nil {
+			t.Skip("REDIS_ADDRESSES not set")
+		}
+		if !ok {
+			addresses = server.Addr()
+		}
+		for _, storageMode := range []redis.StorageMode{redis.StorageModeHash, redis.StorageModeKey} {
+			config := newConfiguration()
+			config.Addresses = strings.Split(addresses, ",")
+			config.StorageMode = storageMode
+			config.Partitions = 16
+			config.MaxEntries = 64
+			tests.TestStash(t, func() stash.Stasher {
+				return newStash(config)
+			})(t)
+		}
+	})
+	t.Run("Sentinel Failover", func(t *testing.T) {
+		const masterName = "gostash"
+
+		//KIM: a failover is simulated by switching the master to a
+		// different redis server and closing the previous master, so
+		// the stash can only be used if the client follows the switch
+		master, err := redistest.NewServer()
+		assert.Nil(t, err)
+		defer master.Close()
+		replica, err := redistest.NewServer()
+		assert.Nil(t, err)
+		defer replica.Close()
+		sentinel, err := redistest.NewSentinel(masterName, master.Addr())
+		assert.Nil(t, err)
+		defer sentinel.Close()
+		config := newConfiguration()
+		config.Username, config.Password, config.Database = "", "", 0
+		config.MasterName = masterName
+		config.SentinelAddresses = []string{sentinel.Addr()}
+		config.Debug = true
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		tests.TestStash(t, func() stash.Stasher { return s })(t)
+		err = sentinel.Failover(replica.Addr())
+		assert.Nil(t, err)
+		err = master.Close()
+		assert.Nil(t, err)
+		//KIM: the client closes connections to the previous master once
+		// notified, a write in flight would be retried (and replaced)
+		time.Sleep(100 * time.Millisecond)
+		tests.TestStash(t, func() stash.Stasher { return s })(t)
+		_, err = s.Write("failover", &stash.Example{String: "failover"})
+		assert.Nil(t, err)
+
+		//validate that the write was made to the new master
+		host, port, err := net.SplitHostPort(replica.Addr())
+		assert.Nil(t, err)
+		config = newConfiguration()
+		config.Address, config.Port = host, port
+		config.Username, config.Password, config.Database = "", "", 0
+		r := redis.New()
+		err = r.Configure(config)
+		assert.Nil(t, err)
+		err = r.Initialize()
+		assert.Nil(t, err)
+		defer r.Shutdown()
+		exampleRead := &stash.Example{}
+		err = r.Read("failover", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, &stash.Example{String: "failover"}, exampleRead)
+	})
+	t.Run("URL", func(t *testing.T) {
+		//KIM: the url takes precedence over the address
+		config := newConfiguration()
+		config.Address = "invalid.invalid"
+		config.URL = fmt.Sprintf("redis://%s/%d", net.JoinHostPort(configuration.Address,
+			configuration.Port), configuration.Database)
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		tests.TestStash(t, func() stash.Stasher { return s })(t)
+		config = newConfiguration()
+		config.URL = "http://localhost"
+		r := redis.New()
+		err := r.Configure(config)
+		assert.NotNil(t, err)
+		err = r.Initialize()
+		assert.NotNil(t, err)
+	})
+	t.Run("TLS", func(t *testing.T) {
+		proxy, err := redistest.NewTLSProxy(net.JoinHostPort(configuration.Address, configuration.Port))
+		assert.Nil(t, err)
+		defer proxy.Close()
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		err = os.WriteFile(caFile, proxy.Certificate(), 0600)
+		assert.Nil(t, err)
+		_, port, err := net.SplitHostPort(proxy.Addr())
+		assert.Nil(t, err)
+		config := newConfiguration()
+		config.FromEnvs(map[string]string{
+			"REDIS_ADDRESS":     "localhost",
+			"REDIS_PORT":        port,
+			"REDIS_TLS_CA_FILE": caFile,
+		})
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		tests.TestStash(t, func() stash.Stasher { return s })(t)
+		config.TLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+		r := redis.New()
+		err = r.Configure(config)
+		assert.Nil(t, err)
+		err = r.Initialize()
+		assert.NotNil(t, err)
+	})
+	t.Run("Pool", func(t *testing.T) {
+		config := newConfiguration()
+		config.FromEnvs(map[string]string{
+			"REDIS_POOL_SIZE":        "2",
+			"REDIS_MIN_IDLE_CONNS":   "1",
+			"REDIS_MAX_RETRIES":      "1",
+			"REDIS_DIAL_TIMEOUT":     "1",
+			"REDIS_READ_TIMEOUT":     "1",
+			"REDIS_WRITE_TIMEOUT":    "1",
+			"REDIS_EVICTION_TIMEOUT": "5",
+		})
+		assert.Equal(t, 2, config.PoolSize)
+		assert.Equal(t, time.Second, config.ReadTimeout)
+		assert.Equal(t, 5*time.Second, config.EvictionTimeout)
+		r := redis.New()
+		assert.Nil(t, r.PoolStats())
+		err := r.Configure(config)
+		assert.Nil(t, err)
+		err = r.Initialize()
+		assert.Nil(t, err)
+		tests.TestStash(t, func() stash.Stasher { return r })(t)
+		poolStats := r.PoolStats()
+		if assert.NotNil(t, poolStats) {
+			assert.LessOrEqual(t, poolStats.TotalConns, uint32(2))
+			assert.Greater(t, poolStats.Hits, uint32(0))
+		}
+		err = r.Shutdown()
+		assert.Nil(t, err)
+		assert.Nil(t, r.PoolStats())
+	})
+	t.Run("Health Check Rate", func(t *testing.T) {
+		//KIM: the status is updated periodically without the stash
+		// being pinged
+		config := newConfiguration()
+		config.HealthCheckRate = 10 * time.Millisecond
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		assert.False(t, s.(stash.HealthChecker).Status().Healthy())
+		time.Sleep(50 * time.Millisecond)
+		assert.True(t, s.(stash.HealthChecker).Status().Healthy())
+	})
+	t.Run("Reconfigure", tests.TestReconfigure(t, func() stash.Stasher {
+		config := newConfiguration()
+		config.EvictionPolicy = stash.FirstInFirstOut
+		return newStash(config)
+	}, func(s stash.Stasher, maxSize int) error {
+		config := newConfiguration()
+		config.EvictionPolicy = stash.FirstInFirstOut
+		config.MaxSize = maxSize
+		return s.(stash.Configurer).Configure(config)
+	}))
+	t.Run("Reconfigure Rates", func(t *testing.T) {
+		//KIM: the evictor is used to observe that the item was removed
+		// by the eviction go routine once it was restarted
+		evictor := &newestFirst{}
+		config := newConfiguration()
+		config.TimeToLive = 10 * time.Millisecond
+		config.EvictionRate = 0
+		s := newStash(config)
+		s.(stash.Parameterizer).SetParameters(evictor)
+		_, err := s.Write("reconfigure_1", &stash.Example{})
+		assert.Nil(t, err)
+		time.Sleep(50 * time.Millisecond)
+		assert.False(t, s.(stash.HealthChecker).Status().Healthy())
+		config = newConfiguration()
+		config.TimeToLive = 10 * time.Millisecond
+		config.EvictionRate = 5 * time.Millisecond
+		config.HealthCheckRate = 5 * time.Millisecond
+		err = s.(stash.Configurer).Configure(config)
+		assert.Nil(t, err)
+		time.Sleep(50 * time.Millisecond)
+		assert.True(t, s.(stash.HealthChecker).Status().Healthy())
+
+		//validate that fields used to connect can't be changed
+		config = newConfiguration()
+		config.HashKey = "reconfigure"
+		err = s.(stash.Configurer).Configure(config)
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 1)
+			assert.Equal(t, "hash_key", configurationError[0].Field)
+		}
+		err = s.(stash.Shutdowner).Shutdown()
+		assert.Nil(t, err)
+		assert.Empty(t, evictor.keys)
+	})
+	t.Run("Reconfigure Concurrent", func(t *testing.T) {
+		const nRoutines, nWrites = 8, 100
+
+		//KIM: this is meant to be run with -race, the configuration
+		// is read by the eviction that follows each write
+		config := newConfiguration()
+		config.EvictionRate = time.Millisecond
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		var wg sync.WaitGroup
+		for i := 0; i < nRoutines; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for j := 0; j < nWrites; j++ {
+					key := fmt.Sprintf("reconfigure_%d_%d", i, j)
+					_, err := s.Write(key, &stash.Example{Int: j})
+					assert.Nil(t, err)
+				}
+			}(i)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			wg.Wait()
+		}()
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			config := newConfiguration()
+			config.EvictionRate = time.Millisecond
+			config.MaxSize = 1024 * (i%4 + 1)
+			err := s.(stash.Configurer).Configure(config)
+			assert.Nil(t, err)
+		}
+	})
+	t.Run("Invalidator", func(t *testing.T) {
+		const waitFor, tick = time.Second, 10 * time.Millisecond
+
+		//KIM: the second stash connects using a proxy so that its
+		// connections can be reset
+		proxy, err := redistest.NewProxy(net.JoinHostPort(configuration.Address, configuration.Port))
+		assert.Nil(t, err)
+		defer proxy.Close()
+		_, port, err := net.SplitHostPort(proxy.Addr())
+		assert.Nil(t, err)
+		newInvalidator := func(config *redis.Configuration, local stash.Stasher) stash.Stasher {
+			s := redis.NewInvalidator(internal.NewLogger(), local)
+			err := s.Configure(config)
+			assert.Nil(t, err)
+			err = s.Initialize()
+			assert.Nil(t, err)
+			return s
+		}
+		config := newConfiguration()
+		config.Debug = true
+		localA, localB := memory.New(), memory.New()
+		err = localA.Configure(memory.Configuration{})
+		assert.Nil(t, err)
+		err = localB.Configure(memory.Configuration{})
+		assert.Nil(t, err)
+		a := newInvalidator(config, localA)
+		defer a.(stash.Shutdowner).Shutdown()
+		config = newConfiguration()
+		config.Address, config.Port, config.Debug = "127.0.0.1", port, true
+		b := newInvalidator(config, localB)
+		defer b.(stash.Shutdowner).Shutdown()
+		tests.TestStash(t, func() stash.Stasher { return a })(t)
+
+		//validate that a write invalidates the item of other stashes
+		//KIM: the invalidation is waited for since it could otherwise
+		// invalidate a subsequent write
+		_, err = localA.Write("invalidate", &stash.Example{String: "stale"})
+		assert.Nil(t, err)
+		_, err = b.Write("invalidate", &stash.Example{String: "b"})
+		assert.Nil(t, err)
+		assert.Eventually(t, func() bool {
+			return localA.Read("invalidate", &stash.Example{}) != nil
+		}, waitFor, tick)
+		_, err = a.Write("invalidate", &stash.Example{String: "a"})
+		assert.Nil(t, err)
+		assert.Eventually(t, func() bool {
+			return localB.Read("invalidate", &stash.Example{}) != nil
+		}, waitFor, tick)
+		exampleRead := &stash.Example{}
+		err = a.Read("invalidate", exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, &stash.Example{String: "a"}, exampleRead)
+
+		//validate that a clear invalidates every item of other stashes
+		_, err = a.Write("clear", &stash.Example{String: "clear"})
+		assert.Nil(t, err)
+		err = b.Clear()
+		assert.Nil(t, err)
+		assert.Eventually(t, func() bool {
+			return localA.Read("clear", &stash.Example{}) != nil
+		}, waitFor, tick)
+
+		//validate that items are invalidated when re-subscribing
+		_, err = localB.Write("reconnect", &stash.Example{String: "reconnect"})
+		assert.Nil(t, err)
+		proxy.Reset()
+		assert.Eventually(t, func() bool {
+			return localB.Read("reconnect", &stash.Example{}) != nil
+		}, waitFor, tick)
+		_, err = b.Write("reconnect", &stash.Example{String: "reconnect"})
+		assert.Nil(t, err)
+		err = b.Read("reconnect", &stash.Example{})
+		assert.Nil(t, err)
+	})
+	t.Run("Local Cache", func(t *testing.T) {
+		const waitFor, tick = time.Second, 10 * time.Millisecond
+
+		for _, storageMode := range []redis.StorageMode{redis.StorageModeHash, redis.StorageModeKey} {
+			config := newConfiguration()
+			config.StorageMode = storageMode
+			config.LocalCacheMaxSize = 1024 * 1024
+			config.Debug = true
+			a := redis.New(internal.NewLogger())
+			err := a.Configure(config)
+			assert.Nil(t, err)
+			if err := a.Initialize(); err != nil {
+				//KIM: client side caching requires CLIENT TRACKING
+				t.Skipf("unable to enable client side caching: %s", err)
+			}
+			err = a.Clear()
+			assert.Nil(t, err)
+			config = newConfiguration()
+			config.StorageMode = storageMode
+			b := newStash(config)
+			tests.TestStash(t, func() stash.Stasher { return a })(t)
+
+			//validate that an item written by another client is
+			// invalidated within the local cache
+			_, err = a.Write("local", &stash.Example{String: "a"})
+			assert.Nil(t, err)
+			exampleRead := &stash.Example{}
+			err = a.Read("local", exampleRead)
+			assert.Nil(t, err)
+			assert.Equal(t, &stash.Example{String: "a"}, exampleRead)
+			_, err = b.Write("local", &stash.Example{String: "b"})
+			assert.Nil(t, err)
+			assert.Eventually(t, func() bool {
+				exampleRead := &stash.Example{}
+				err := a.Read("local", exampleRead)
+				return err == nil && exampleRead.String == "b"
+			}, waitFor, tick)
+
+			//validate that an item deleted by another client is
+			// invalidated within the local cache
+			err = b.Delete("local")
+			assert.Nil(t, err)
+			assert.Eventually(t, func() bool {
+				return a.Read("local", &stash.Example{}) != nil
+			}, waitFor, tick)
+			err = a.Shutdown()
+			assert.Nil(t, err)
+			err = b.(stash.Shutdowner).Shutdown()
+			assert.Nil(t, err)
+		}
+	})
+	t.Run("Local Cache Read Statistics", func(t *testing.T) {
+		//KIM: the reads served by the local cache are buffered, the
+		// number of times read shouldn't count the buffered reads twice
+		evictor := &timesRead{}
+		config := newConfiguration()
+		config.EvictionPolicy = stash.LeastFrequentlyUsed
+		config.LocalCacheMaxSize = 1024 * 1024
+		config.StatsFlushRate = 100 * time.Millisecond
+		s := redis.New(internal.NewLogger(), evictor)
+		err := s.Configure(config)
+		assert.Nil(t, err)
+		if err := s.Initialize(); err != nil {
+			//KIM: client side caching requires CLIENT TRACKING
+			t.Skipf("unable to enable client side caching: %s", err)
+		}
+		defer s.Shutdown()
+		err = s.Clear()
+		assert.Nil(t, err)
+		_, err = s.Write("local_stats", &stash.Example{String: "local_stats"})
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			err := s.Read("local_stats", &stash.Example{})
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 3, evictor.max)
+
+		//validate that the reads are counted once they're flushed
+		time.Sleep(250 * time.Millisecond)
+		err = s.Read("local_stats", &stash.Example{})
+		assert.Nil(t, err)
+		err = s.Read("local_stats", &stash.Example{})
+		assert.Nil(t, err)
+		assert.Equal(t, 5, evictor.max)
+	})
+	t.Run("Eviction Leader", func(t *testing.T) {
+		const waitFor, tick = time.Second, 10 * time.Millisecond
+
+		type leader interface {
+			stash.Shutdowner
+			Leader() bool
+		}
+
+		config := newConfiguration()
+		config.FromEnvs(map[string]string{"REDIS_EVICTION_LEADER": "true"})
+		config.EvictionRate = 10 * time.Millisecond
+		config.Debug = true
+		a, b := newStash(config).(leader), newStash(config).(leader)
+		defer func() {
+			_ = a.Shutdown()
+			_ = b.Shutdown()
+		}()
+
+		//validate that a single leader is elected
+		assert.Eventually(t, func() bool {
+			return a.Leader() != b.Leader()
+		}, waitFor, tick)
+		time.Sleep(50 * time.Millisecond)
+		assert.NotEqual(t, a.Leader(), b.Leader())
+
+		//validate that the lease is handed over when the leader stops
+		if b.Leader() {
+			a, b = b, a
+		}
+		err := a.Shutdown()
+		assert.Nil(t, err)
+		assert.False(t, a.Leader())
+		assert.Eventually(t, func() bool {
+			return b.Leader()
+		}, waitFor, tick)
+	})
+	t.Run("Time To Live Key", func(t *testing.T) {
+		//KIM: the time to live is enforced by redis, so the item
+		// will expire without the stash being accessed
+		config := newConfiguration()
+		config.StorageMode = redis.StorageModeKey
+		config.TimeToLive = 50 * time.Millisecond
+		s := newStash(config)
+		_, err := s.Write("ttl_key", &stash.Example{String: "ttl_key"})
+		assert.Nil(t, err)
+		err = s.Read("ttl_key", &stash.Example{})
+		assert.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+		err = s.Read("ttl_key", &stash.Example{})
+		assert.NotNil(t, err)
+	})
+	t.Run("Clear Key", func(t *testing.T) {
+		//KIM: keys that share the key prefix but weren't written by
+		// the stash must not be deleted
+		config := newConfiguration()
+		config.StorageMode = redis.StorageModeKey
+		config.Partitions = 2
+		s := newStash(config)
+		client := goredis.NewClient(&goredis.Options{
+			Addr:     net.JoinHostPort(config.Address, config.Port),
+			Username: config.Username,
+			Password: config.Password,
+			DB:       config.Database,
+		})
+		defer client.Close()
+		ctx := context.Background()
+		foreign := config.KeyPrefix + "foreign"
+		err := client.Set(ctx, foreign, "foreign", 0).Err()
+		assert.Nil(t, err)
+		defer client.Del(ctx, foreign)
+		for _, key := range []string{"clear_a", "clear_b", "clear_c"} {
+			_, err := s.Write(key, &stash.Example{String: key})
+			assert.Nil(t, err)
+		}
+		err = s.Clear()
+		assert.Nil(t, err)
+		for _, key := range []string{"clear_a", "clear_b", "clear_c"} {
+			err := s.Read(key, &stash.Example{})
+			assert.NotNil(t, err)
+		}
+		value, err := client.Get(ctx, foreign).Result()
+		assert.Nil(t, err)
+		assert.Equal(t, "foreign", value)
+		config = newConfiguration()
+		config.StorageMode = redis.StorageModeKey
+		config.KeyPrefix = ""
+		err = config.Validate()
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Equal(t, "key_prefix", configurationError[0].Field)
+		}
+	})
+	t.Run("Health Check", func(t *testing.T) {
+		s := newStash(newConfiguration())
+		tests.TestHealthCheck(t, func() stash.HealthChecker {
+			return s.(stash.HealthChecker)
+		}, func() stash.HealthChecker {
+			return redis.New()
+		})(t)
+		err := s.(stash.Shutdowner).Shutdown()
+		assert.Nil(t, err)
+		err = s.(stash.Pinger).Ping(context.Background())
+		assert.NotNil(t, err)
+		assert.False(t, s.(stash.HealthChecker).Status().Healthy())
+	})
+	t.Run("Validate", func(t *testing.T) {
+		config := newConfiguration()
+		err := config.FromEnvs(map[string]string{
+			"REDIS_TIMEOUT":          "5s",
+			"REDIS_READ_TIMEOUT":     "2",
+			"REDIS_STATS_FLUSH_RATE": "250ms",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 5*time.Second, config.Timeout)
+		assert.Equal(t, 2*time.Second, config.ReadTimeout)
+		assert.Equal(t, 250*time.Millisecond, config.StatsFlushRate)
+		err = config.FromEnvs(map[string]string{
+			"REDIS_POOL_SIZE":       "two",
+			"REDIS_WRITE_TIMEOUT":   "soon",
+			"REDIS_EVICTION_LEADER": "maybe",
+		})
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 3)
+			assert.Equal(t, "REDIS_EVICTION_LEADER", configurationError[0].Field)
+		}
+		r := redis.New()
+		err = r.Configure(map[string]string{"REDIS_MAX_RETRIES": "none"})
+		assert.NotNil(t, err)
+		config = newConfiguration()
+		assert.Nil(t, config.Validate())
+		config.EvictionPolicy = "most_recently_used"
+		config.StorageMode = "list"
+		config.Timeout = 0
+		config.MaxSize = -1
+		config.MasterName = "gostash"
+		err = config.Validate()
+		if assert.ErrorAs(t, err, &configurationError) {
+			var fields []string
+			for _, fieldError := range configurationError {
+				fields = append(fields, fieldError.Field)
+			}
+			assert.Equal(t, []string{"eviction_policy", "max_size", "sentinel_addresses",
+				"storage_mode", "timeout"}, fields)
+		}
+		err = r.Configure(config)
+		assert.NotNil(t, err)
+		err = r.Initialize()
+		assert.NotNil(t, err)
+		config = newConfiguration()
+		config.EvictionPolicy = stash.AdaptiveReplacement
+		assert.NotNil(t, config.Validate())
+		config = newConfiguration()
+		config.EvictionPolicy = stash.LeastRecentlyUsed
+		config.LocalCacheMaxSize = 1024
+		err = config.Validate()
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 1)
+			assert.Equal(t, "stats_flush_rate", configurationError[0].Field)
+		}
+		config.StatsFlushRate = time.Second
+		assert.Nil(t, config.Validate())
+	})
+	t.Run("Load", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		err := os.WriteFile(path, []byte(fmt.Sprintf(`{"address": %q, "port": "1",
+			"storage_mode": "key", "stats_flush_rate": "100ms", "addresses": ["seed:6379"]}`,
+			configuration.Address)), 0600)
+		assert.Nil(t, err)
+		t.Setenv("TEST_REDIS_PORT", configuration.Port)
+		config := newConfiguration()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		config.RegisterFlags(fs, "redis-")
+		err = fs.Parse([]string{"-redis-addresses=", "-redis-key-prefix", "gostash_load:",
+			"-redis-eviction-leader"})
+		assert.Nil(t, err)
+		err = config.Load(path, "TEST_", fs)
+		assert.Nil(t, err)
+		assert.Equal(t, configuration.Port, config.Port)
+		assert.Equal(t, redis.StorageModeKey, config.StorageMode)
+		assert.Equal(t, 100*time.Millisecond, config.StatsFlushRate)
+		assert.Equal(t, "gostash_load:", config.KeyPrefix)
+		assert.Empty(t, config.Addresses)
+		assert.True(t, config.EvictionLeader)
+		assert.Equal(t, "the prefix of the key of each item (key storage mode)",
+			fs.Lookup("redis-key-prefix").Usage)
+		err = os.WriteFile(path, []byte(`{"port": 6379, "partitions": "two", "timeout": "forever"}`), 0600)
+		assert.Nil(t, err)
+		err = newConfiguration().FromFile(path)
+		var configurationError stash.ConfigurationError
+		if assert.ErrorAs(t, err, &configurationError) {
+			assert.Len(t, configurationError, 3)
+		}
+		s := newStash(config)
+		defer s.(stash.Shutdowner).Shutdown()
+		tests.TestStash(t, func() stash.Stasher { return s })(t)
+	})
 }
@@ -0,0 +1,981 @@
+package redistest
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	errors "github.com/pkg/errors"
+)
+
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errInteger   = errors.New("value is not an integer or out of range")
+	errFloat     = errors.New("value is not a valid float")
+	errSyntax    = errors.New("syntax error")
+)
+
+// database is a set of keys
+type database map[string]*entry
+
+// entry is the value of a key, the value is a string, a hash
+// (map[string]string) or a sorted set (sortedSet)
+type entry struct {
+	value   any
+	expires time.Time
+}
+
+// sortedSet maps members to their scores, members are sorted
+// when a range is requested
+type sortedSet map[string]float64
+
+// sorted returns the members sorted by score and then member
+func (z sortedSet) sorted() []string {
+	members := make([]string, 0, len(z))
+	for member := range z {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if z[members[i]] != z[members[j]] {
+			return z[members[i]] < z[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+// command is a command and its arity, a negative arity is the
+// minimum number of arguments (including the name of the command)
+type command struct {
+	arity int
+	fn    func(s *Server, c *client, args []string) any
+}
+
+var commands map[string]command
+
+// KIM: the commands are registered within init since the scripting
+// commands execute commands (an initialization cycle)
+func init() {
+	commands = map[string]command{
+		"PING":          {-1, cmdPing},
+		"ECHO":          {2, cmdEcho},
+		"QUIT":          {1, cmdOK},
+		"AUTH":          {-2, cmdOK},
+		"SELECT":        {2, cmdSelect},
+		"CLIENT":        {-2, cmdClient},
+		"CLUSTER":       {-2, cmdCluster},
+		"FLUSHDB":       {-1, cmdFlushDB},
+		"FLUSHALL":      {-1, cmdFlushAll},
+		"DBSIZE":        {1, cmdDBSize},
+		"DEL":           {-2, cmdDel},
+		"UNLINK":        {-2, cmdDel},
+		"EXISTS":        {-2, cmdExists},
+		"TYPE":          {2, cmdType},
+		"EXPIRE":        {3, cmdExpire},
+		"PEXPIRE":       {3, cmdExpire},
+		"PERSIST":       {2, cmdPersist},
+		"TTL":           {2, cmdTTL},
+		"PTTL":          {2, cmdTTL},
+		"KEYS":          {2, cmdKeys},
+		"SCAN":          {-2, cmdScan},
+		"GET":           {2, cmdGet},
+		"SET":           {-3, cmdSet},
+		"INCR":          {2, cmdIncrBy},
+		"DECR":          {2, cmdIncrBy},
+		"INCRBY":        {3, cmdIncrBy},
+		"DECRBY":        {3, cmdIncrBy},
+		"HGET":          {3, cmdHGet},
+		"HSET":          {-4, cmdHSet},
+		"HMSET":         {-4, cmdHSet},
+		"HDEL":          {-3, cmdHDel},
+		"HEXISTS":       {3, cmdHExists},
+		"HLEN":          {2, cmdHLen},
+		"HKEYS":         {2, cmdHGetAll},
+		"HVALS":         {2, cmdHGetAll},
+		"HGETALL":       {2, cmdHGetAll},
+		"HMGET":         {-3, cmdHMGet},
+		"HINCRBY":       {4, cmdHIncrBy},
+		"HSCAN":         {-3, cmdScan},
+		"ZADD":          {-4, cmdZAdd},
+		"ZREM":          {-3, cmdZRem},
+		"ZCARD":         {2, cmdZCard},
+		"ZSCORE":        {3, cmdZScore},
+		"ZRANK":         {3, cmdZRank},
+		"ZCOUNT":        {4, cmdZCount},
+		"ZRANGE":        {-4, cmdZRange},
+		"ZRANGEBYSCORE": {-4, cmdZRangeByScore},
+		"EVAL":          {-3, cmdEval},
+		"EVALSHA":       {-3, cmdEval},
+		"SCRIPT":        {-2, cmdScript},
+		"SUBSCRIBE":     {-2, cmdSubscribe},
+		"UNSUBSCRIBE":   {-1, cmdUnsubscribe},
+		"PUBLISH":       {3, cmdPublish},
+	}
+}
+
+func cmdOK(*Server, *client, []string) any {
+	return status("OK")
+}
+
+func cmdPing(s *Server, c *client, args []string) any {
+	message := ""
+	if len(args) > 1 {
+		message = args[1]
+	}
+	if len(c.channels) > 0 {
+		return []string{"pong", message}
+	}
+	if len(args) > 1 {
+		return message
+	}
+	return status("PONG")
+}
+
+func cmdEcho(s *Server, c *client, args []string) any {
+	return args[1]
+}
+
+func cmdSelect(s *Server, c *client, args []string) any {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errInteger
+	}
+	if index < 0 || index > 15 {
+		return errors.New("DB index is out of range")
+	}
+	c.database = index
+	return status("OK")
+}
+
+func cmdClient(s *Server, c *client, args []string) any {
+	switch strings.ToUpper(args[1]) {
+	default:
+		return errors.Errorf("unknown subcommand '%s'", args[1])
+	case "ID":
+		return c.id
+	case "SETNAME", "SETINFO":
+		return status("OK")
+	case "TRACKING":
+		if len(args) < 3 {
+			return errors.New("wrong number of arguments for 'client|tracking' command")
+		}
+		return s.clientTracking(c, args[2:])
+	}
+}
+
+// cmdCluster reports the server as a cluster with a single node that
+// serves every slot, it can be used to test cluster clients
+func cmdCluster(s *Server, c *client, args []string) any {
+	switch strings.ToUpper(args[1]) {
+	default:
+		return errors.Errorf("unknown subcommand '%s'", args[1])
+	case "SLOTS":
+		host, port, _ := net.SplitHostPort(s.Addr())
+		n, _ := strconv.Atoi(port)
+		return []any{[]any{0, 16383, []any{host, n, "redistest"}}}
+	}
+}
+
+func cmdFlushDB(s *Server, c *client, args []string) any {
+	s.flush(c, c.database)
+	return status("OK")
+}
+
+func cmdFlushAll(s *Server, c *client, args []string) any {
+	s.flush(c, -1)
+	return status("OK")
+}
+
+func cmdDBSize(s *Server, c *client, args []string) any {
+	n := 0
+	for key := range s.database(c) {
+		if _, ok := s.lookup(c, key); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdDel(s *Server, c *client, args []string) any {
+	n := 0
+	for _, key := range args[1:] {
+		if s.remove(c, key) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, c *client, args []string) any {
+	n := 0
+	for _, key := range args[1:] {
+		if _, ok := s.lookup(c, key); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(s *Server, c *client, args []string) any {
+	e, ok := s.lookup(c, args[1])
+	if !ok {
+		return status("none")
+	}
+	switch e.value.(type) {
+	default:
+		return status("string")
+	case map[string]string:
+		return status("hash")
+	case sortedSet:
+		return status("zset")
+	}
+}
+
+func cmdExpire(s *Server, c *client, args []string) any {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errInteger
+	}
+	e, ok := s.lookup(c, args[1])
+	if !ok {
+		return 0
+	}
+	unit := time.Second
+	if strings.EqualFold(args[0], "PEXPIRE") {
+		unit = time.Millisecond
+	}
+	if n <= 0 {
+		s.remove(c, args[1])
+		return 1
+	}
+	e.expires = time.Now().Add(time.Duration(n) * unit)
+	return 1
+}
+
+func cmdPersist(s *Server, c *client, args []string) any {
+	e, ok := s.lookup(c, args[1])
+	if !ok || e.expires.IsZero() {
+		return 0
+	}
+	e.expires = time.Time{}
+	return 1
+}
+
+func cmdTTL(s *Server, c *client, args []string) any {
+	e, ok := s.lookup(c, args[1])
+	if !ok {
+		return -2
+	}
+	if e.expires.IsZero() {
+		return -1
+	}
+	unit := time.Second
+	if strings.EqualFold(args[0], "PTTL") {
+		unit = time.Millisecond
+	}
+	return int64(math.Ceil(float64(time.Until(e.expires)) / float64(unit)))
+}
+
+func cmdKeys(s *Server, c *client, args []string) any {
+	keys := []string{}
+	for _, key := range s.keys(c) {
+		if match(args[1], key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keys returns the keys of the selected database (that haven't
+// expired) sorted
+func (s *Server) keys(c *client) []string {
+	keys := make([]string, 0, len(s.database(c)))
+	for key := range s.database(c) {
+		if _, ok := s.lookup(c, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cmdScan implements SCAN and HSCAN, the cursor is the offset of
+// the sorted keys (or fields)
+func cmdScan(s *Server, c *client, args []string) any {
+	var hash map[string]string
+	var items []string
+
+	if strings.EqualFold(args[0], "HSCAN") {
+		e, ok := s.lookup(c, args[1])
+		if ok {
+			if hash, ok = e.value.(map[string]string); !ok {
+				return errWrongType
+			}
+		}
+		for field := range hash {
+			items = append(items, field)
+		}
+		sort.Strings(items)
+		args = args[1:]
+	} else {
+		items = s.keys(c)
+	}
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		return errors.New("invalid cursor")
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		default:
+			return errSyntax
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return errSyntax
+			}
+		}
+	}
+	results := []string{}
+	for ; cursor < len(items) && count > 0; cursor, count = cursor+1, count-1 {
+		if !match(pattern, items[cursor]) {
+			continue
+		}
+		results = append(results, items[cursor])
+		if hash != nil {
+			results = append(results, hash[items[cursor]])
+		}
+	}
+	if cursor >= len(items) {
+		cursor = 0
+	}
+	return []any{strconv.Itoa(cursor), results}
+}
+
+// str returns the string value of the given key
+func (s *Server) str(c *client, key string) (string, bool, error) {
+	e, ok := s.lookup(c, key)
+	if !ok {
+		return "", false, nil
+	}
+	value, ok := e.value.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return value, true, nil
+}
+
+func cmdGet(s *Server, c *client, args []string) any {
+	value, ok, err := s.str(c, args[1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func cmdSet(s *Server, c *client, args []string) any {
+	var expires time.Time
+	var nx, xx bool
+
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		default:
+			return errSyntax
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errInteger
+			}
+			if n <= 0 {
+				return errors.New("invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		}
+	}
+	if _, exists := s.lookup(c, args[1]); (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	s.database(c)[args[1]] = &entry{value: args[2], expires: expires}
+	s.modified(c, args[1])
+	return status("OK")
+}
+
+func cmdIncrBy(s *Server, c *client, args []string) any {
+	increment := int64(1)
+	if len(args) > 2 {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errInteger
+		}
+		increment = n
+	}
+	if name := strings.ToUpper(args[0]); name == "DECR" || name == "DECRBY" {
+		increment = -increment
+	}
+	value, ok, err := s.str(c, args[1])
+	if err != nil {
+		return err
+	}
+	n := int64(0)
+	if ok {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errInteger
+		}
+	}
+	n += increment
+	if e, ok := s.lookup(c, args[1]); ok {
+		e.value = strconv.FormatInt(n, 10)
+	} else {
+		s.database(c)[args[1]] = &entry{value: strconv.FormatInt(n, 10)}
+	}
+	s.modified(c, args[1])
+	return n
+}
+
+// hash returns the hash of the given key, if create is true, the hash
+// is created if it doesn't exist
+func (s *Server) hash(c *client, key string, create bool) (map[string]string, error) {
+	e, ok := s.lookup(c, key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		hash := make(map[string]string)
+		s.database(c)[key] = &entry{value: hash}
+		return hash, nil
+	}
+	hash, ok := e.value.(map[string]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return hash, nil
+}
+
+func cmdHGet(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	value, ok := hash[args[2]]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func cmdHSet(s *Server, c *client, args []string) any {
+	if len(args)%2 != 0 {
+		return errors.Errorf("wrong number of arguments for '%s' command", strings.ToLower(args[0]))
+	}
+	hash, err := s.hash(c, args[1], true)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := hash[args[i]]; !ok {
+			n++
+		}
+		hash[args[i]] = args[i+1]
+	}
+	s.modified(c, args[1])
+	if strings.EqualFold(args[0], "HMSET") {
+		return status("OK")
+	}
+	return n
+}
+
+func cmdHDel(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, field := range args[2:] {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			n++
+		}
+	}
+	if n > 0 {
+		if len(hash) == 0 {
+			delete(s.database(c), args[1])
+		}
+		s.modified(c, args[1])
+	}
+	return n
+}
+
+func cmdHExists(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	if _, ok := hash[args[2]]; ok {
+		return 1
+	}
+	return 0
+}
+
+func cmdHLen(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	return len(hash)
+}
+
+// cmdHGetAll implements HGETALL, HKEYS and HVALS, fields are sorted
+func cmdHGetAll(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	results := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		switch strings.ToUpper(args[0]) {
+		case "HKEYS":
+			results = append(results, field)
+		case "HVALS":
+			results = append(results, hash[field])
+		default:
+			results = append(results, field, hash[field])
+		}
+	}
+	return results
+}
+
+func cmdHMGet(s *Server, c *client, args []string) any {
+	hash, err := s.hash(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	results := make([]any, 0, len(args)-2)
+	for _, field := range args[2:] {
+		if value, ok := hash[field]; ok {
+			results = append(results, value)
+			continue
+		}
+		results = append(results, nil)
+	}
+	return results
+}
+
+func cmdHIncrBy(s *Server, c *client, args []string) any {
+	increment, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errInteger
+	}
+	hash, err := s.hash(c, args[1], true)
+	if err != nil {
+		return err
+	}
+	n := int64(0)
+	if value, ok := hash[args[2]]; ok {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("hash value is not an integer")
+		}
+	}
+	n += increment
+	hash[args[2]] = strconv.FormatInt(n, 10)
+	s.modified(c, args[1])
+	return n
+}
+
+// zset returns the sorted set of the given key, if create is true, the
+// sorted set is created if it doesn't exist
+func (s *Server) zset(c *client, key string, create bool) (sortedSet, error) {
+	e, ok := s.lookup(c, key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		z := make(sortedSet)
+		s.database(c)[key] = &entry{value: z}
+		return z, nil
+	}
+	z, ok := e.value.(sortedSet)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+// formatScore formats a score the same way as redis (the shortest
+// representation)
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// cmdZAdd implements ZADD, options (e.g. NX) aren't supported
+func cmdZAdd(s *Server, c *client, args []string) any {
+	if len(args)%2 != 0 {
+		return errSyntax
+	}
+	scores := make(map[string]float64)
+	for i := 2; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return errFloat
+		}
+		scores[args[i+1]] = score
+	}
+	z, err := s.zset(c, args[1], true)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for member, score := range scores {
+		if _, ok := z[member]; !ok {
+			n++
+		}
+		z[member] = score
+	}
+	s.modified(c, args[1])
+	return n
+}
+
+func cmdZRem(s *Server, c *client, args []string) any {
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, member := range args[2:] {
+		if _, ok := z[member]; ok {
+			delete(z, member)
+			n++
+		}
+	}
+	if n > 0 {
+		if len(z) == 0 {
+			delete(s.database(c), args[1])
+		}
+		s.modified(c, args[1])
+	}
+	return n
+}
+
+func cmdZCard(s *Server, c *client, args []string) any {
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	return len(z)
+}
+
+func cmdZScore(s *Server, c *client, args []string) any {
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	score, ok := z[args[2]]
+	if !ok {
+		return nil
+	}
+	return formatScore(score)
+}
+
+func cmdZRank(s *Server, c *client, args []string) any {
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	if _, ok := z[args[2]]; !ok {
+		return nil
+	}
+	for i, member := range z.sorted() {
+		if member == args[2] {
+			return i
+		}
+	}
+	return nil
+}
+
+func cmdZCount(s *Server, c *client, args []string) any {
+	lo, loExclusive, err := parseBound(args[2])
+	if err != nil {
+		return err
+	}
+	hi, hiExclusive, err := parseBound(args[3])
+	if err != nil {
+		return err
+	}
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, score := range z {
+		if score < lo || (loExclusive && score == lo) ||
+			score > hi || (hiExclusive && score == hi) {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// withScores returns the given members (and their scores)
+func withScores(z sortedSet, members []string, scores bool) []string {
+	if !scores {
+		return members
+	}
+	results := make([]string, 0, 2*len(members))
+	for _, member := range members {
+		results = append(results, member, formatScore(z[member]))
+	}
+	return results
+}
+
+// cmdZRange implements ZRANGE by index, options other than
+// WITHSCORES (e.g. BYSCORE) aren't supported
+func cmdZRange(s *Server, c *client, args []string) any {
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errInteger
+	}
+	stop, err := strconv.Atoi(args[3])
+	if err != nil {
+		return errInteger
+	}
+	scores := false
+	for _, option := range args[4:] {
+		if !strings.EqualFold(option, "WITHSCORES") {
+			return errSyntax
+		}
+		scores = true
+	}
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	members := z.sorted()
+	if start < 0 {
+		start = len(members) + start
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop < 0 {
+		stop = len(members) + stop
+	}
+	if stop >= len(members) {
+		stop = len(members) - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+	return withScores(z, members[start:stop+1], scores)
+}
+
+// parseBound parses the minimum (or maximum) of a range of scores,
+// a bound prefixed with ( is exclusive
+func parseBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	score, err := strconv.ParseFloat(strings.TrimPrefix(bound, "("), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, errors.New("min or max is not a float")
+	}
+	return score, exclusive, nil
+}
+
+func cmdZRangeByScore(s *Server, c *client, args []string) any {
+	lo, loExclusive, err := parseBound(args[2])
+	if err != nil {
+		return err
+	}
+	hi, hiExclusive, err := parseBound(args[3])
+	if err != nil {
+		return err
+	}
+	scores, offset, count := false, 0, -1
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		default:
+			return errSyntax
+		case "WITHSCORES":
+			scores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return errSyntax
+			}
+			if offset, err = strconv.Atoi(args[i+1]); err != nil {
+				return errInteger
+			}
+			if count, err = strconv.Atoi(args[i+2]); err != nil {
+				return errInteger
+			}
+			i += 2
+		}
+	}
+	z, err := s.zset(c, args[1], false)
+	if err != nil {
+		return err
+	}
+	members := []string{}
+	if offset < 0 {
+		return members
+	}
+	for _, member := range z.sorted() {
+		score := z[member]
+		if score < lo || (loExclusive && score == lo) ||
+			score > hi || (hiExclusive && score == hi) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count == 0 {
+			break
+		}
+		members = append(members, member)
+		count--
+	}
+	return withScores(z, members, scores)
+}
+
+func cmdSubscribe(s *Server, c *client, args []string) any {
+	results := make(replies, 0, len(args)-1)
+	for _, channel := range args[1:] {
+		s.subscribe(c, channel)
+		results = append(results, []any{"subscribe", channel, len(c.channels)})
+	}
+	return results
+}
+
+func cmdUnsubscribe(s *Server, c *client, args []string) any {
+	channels := args[1:]
+	if len(channels) == 0 {
+		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	if len(channels) == 0 {
+		return []any{"unsubscribe", nil, 0}
+	}
+	results := make(replies, 0, len(channels))
+	for _, channel := range channels {
+		s.unsubscribe(c, channel)
+		results = append(results, []any{"unsubscribe", channel, len(c.channels)})
+	}
+	return results
+}
+
+func cmdPublish(s *Server, c *client, args []string) any {
+	return s.publish(args[1], args[2])
+}
+
+// match reports whether the given string matches the given glob-style
+// pattern (e.g. KEYS or SCAN), the pattern supports *, ?, [...] and
+// escaping using \
+func match(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if match(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				//KIM: an unterminated class is treated as a literal
+				if str[0] != '[' {
+					return false
+				}
+				str = str[1:]
+				break
+			}
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				switch {
+				case class[i] == '\\' && i+1 < len(class):
+					i++
+					matched = matched || class[i] == str[0]
+				case i+2 < len(class) && class[i+1] == '-':
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (str[0] >= lo && str[0] <= hi)
+					i += 2
+				default:
+					matched = matched || class[i] == str[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern, str = pattern[end+1:], str[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || str[0] != pattern[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
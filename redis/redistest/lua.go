@@ -0,0 +1,350 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// script is a compiled lua script
+type script struct {
+	proto *lua.FunctionProto
+}
+
+func sha1hex(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// load will compile the given script and cache it, it returns the
+// sha1 of the script
+func (s *Server) load(source string) (string, error) {
+	sha := sha1hex(source)
+	if _, ok := s.scripts[sha]; ok {
+		return sha, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), "@user_script")
+	if err != nil {
+		return "", errors.Errorf("Error compiling script (new function): %s", err)
+	}
+	proto, err := lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", errors.Errorf("Error compiling script (new function): %s", err)
+	}
+	s.scripts[sha] = &script{proto: proto}
+	return sha, nil
+}
+
+// cmdEval implements EVAL and EVALSHA, scripts are executed atomically
+// since the server is locked while executing a command
+func cmdEval(s *Server, c *client, args []string) any {
+	var sha string
+
+	if strings.EqualFold(args[0], "EVALSHA") {
+		sha = strings.ToLower(args[1])
+	} else {
+		var err error
+		if sha, err = s.load(args[1]); err != nil {
+			return err
+		}
+	}
+	script, ok := s.scripts[sha]
+	if !ok {
+		return errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errInteger
+	}
+	if numKeys < 0 || numKeys > len(args)-3 {
+		return errors.New("Number of keys can't be greater than number of args")
+	}
+	L := s.newState(c)
+	defer L.Close()
+	L.SetGlobal("KEYS", stringsToTable(L, args[3:3+numKeys]))
+	L.SetGlobal("ARGV", stringsToTable(L, args[3+numKeys:]))
+	L.Push(L.NewFunctionFromProto(script.proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		return errors.Errorf("Error running script (call to f_%s): %s", sha, err)
+	}
+	return fromLua(L.Get(-1))
+}
+
+func cmdScript(s *Server, c *client, args []string) any {
+	switch strings.ToUpper(args[1]) {
+	default:
+		return errors.Errorf("unknown subcommand '%s'", args[1])
+	case "LOAD":
+		if len(args) != 3 {
+			return errors.New("wrong number of arguments for 'script|load' command")
+		}
+		sha, err := s.load(args[2])
+		if err != nil {
+			return err
+		}
+		return sha
+	case "EXISTS":
+		results := make([]any, 0, len(args)-2)
+		for _, sha := range args[2:] {
+			if _, ok := s.scripts[strings.ToLower(sha)]; ok {
+				results = append(results, 1)
+				continue
+			}
+			results = append(results, 0)
+		}
+		return results
+	case "FLUSH":
+		s.scripts = make(map[string]*script)
+		return status("OK")
+	}
+}
+
+// newState creates the lua state used to execute a script, only the
+// libraries available to redis scripts (and used by the stash) are
+// opened
+func (s *Server) newState(c *client) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	call := func(protected bool) lua.LGFunction {
+		return func(L *lua.LState) int {
+			args := make([]string, 0, L.GetTop())
+			for i := 1; i <= L.GetTop(); i++ {
+				switch v := L.Get(i).(type) {
+				default:
+					L.RaiseError("Lua redis lib command arguments must be strings or integers")
+				case lua.LString:
+					args = append(args, string(v))
+				case lua.LNumber:
+					args = append(args, strconv.FormatFloat(float64(v), 'g', 17, 64))
+				}
+			}
+			if len(args) == 0 {
+				L.RaiseError("Please specify at least one argument for this redis lib call")
+			}
+			var reply any
+			switch strings.ToUpper(args[0]) {
+			case "EVAL", "EVALSHA", "SCRIPT", "SUBSCRIBE", "UNSUBSCRIBE", "SELECT", "QUIT":
+				reply = errors.New("This Redis command is not allowed from script")
+			default:
+				reply = s.command(c, args)
+			}
+			if err, ok := reply.(error); ok && !protected {
+				L.RaiseError("%s", err)
+			}
+			L.Push(toLua(L, reply))
+			return 1
+		}
+	}
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  call(false),
+		"pcall": call(true),
+		"error_reply": func(L *lua.LState) int {
+			table := L.NewTable()
+			table.RawSetString("err", lua.LString(L.CheckString(1)))
+			L.Push(table)
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			table := L.NewTable()
+			table.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(table)
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1hex(L.CheckString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int { return 0 },
+	})
+	L.SetGlobal("redis", redis)
+	cjson := L.NewTable()
+	L.SetFuncs(cjson, map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			bytes, err := json.Marshal(fromLuaJSON(L.CheckAny(1)))
+			if err != nil {
+				L.RaiseError("%s", err)
+			}
+			L.Push(lua.LString(bytes))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var v any
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+				L.RaiseError("%s", err)
+			}
+			L.Push(toLuaJSON(L, v))
+			return 1
+		},
+	})
+	L.SetGlobal("cjson", cjson)
+	return L
+}
+
+func stringsToTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// toLua converts a reply to a lua value using the same conversion as
+// redis, null is converted to false and status (or error) replies are
+// converted to tables with an ok (or err) field
+func toLua(L *lua.LState, reply any) lua.LValue {
+	switch v := reply.(type) {
+	default:
+		return lua.LFalse
+	case string:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case status:
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(v))
+		return table
+	case error:
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(v.Error()))
+		return table
+	case []string:
+		table := L.CreateTable(len(v), 0)
+		for _, s := range v {
+			table.Append(lua.LString(s))
+		}
+		return table
+	case []any:
+		table := L.CreateTable(len(v), 0)
+		for i, item := range v {
+			table.RawSetInt(i+1, toLua(L, item))
+		}
+		return table
+	}
+}
+
+// fromLua converts a lua value to a reply using the same conversion as
+// redis; numbers are truncated to integers and tables are converted to
+// arrays (up to the first nil) unless they have an ok (or err) field
+func fromLua(value lua.LValue) any {
+	switch v := value.(type) {
+	default:
+		return nil
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return int64(v)
+	case lua.LBool:
+		if v {
+			return 1
+		}
+		return nil
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return errors.New(string(err))
+		}
+		if ok, isStatus := v.RawGetString("ok").(lua.LString); isStatus {
+			return status(ok)
+		}
+		results := []any{}
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			results = append(results, fromLua(item))
+		}
+		return results
+	}
+}
+
+// fromLuaJSON converts a lua value to a value that can be encoded as
+// json, tables with consecutive integer keys are arrays and integral
+// numbers are encoded as integers
+func fromLuaJSON(value lua.LValue) any {
+	switch v := value.(type) {
+	default:
+		return nil
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1e15 {
+			return int64(f)
+		}
+		return float64(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 && n == countKeys(v) {
+			array := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				array = append(array, fromLuaJSON(v.RawGetInt(i)))
+			}
+			return array
+		}
+		object := make(map[string]any)
+		v.ForEach(func(key, value lua.LValue) {
+			object[key.String()] = fromLuaJSON(value)
+		})
+		return object
+	}
+}
+
+func countKeys(table *lua.LTable) int {
+	n := 0
+	table.ForEach(func(lua.LValue, lua.LValue) { n++ })
+	return n
+}
+
+// toLuaJSON converts a decoded json value to a lua value
+func toLuaJSON(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	default:
+		return lua.LNil
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case []any:
+		table := L.CreateTable(len(v), 0)
+		for i, item := range v {
+			table.RawSetInt(i+1, toLuaJSON(L, item))
+		}
+		return table
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		table := L.CreateTable(0, len(v))
+		for _, key := range keys {
+			table.RawSetString(key, toLuaJSON(L, v[key]))
+		}
+		return table
+	}
+}
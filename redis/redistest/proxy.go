@@ -0,0 +1,170 @@
+package redistest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy forwards connections to a redis server, it can be used to
+// simulate network failures (Reset) or test tls without configuring
+// redis (NewTLSProxy)
+type Proxy struct {
+	sync.Mutex
+	sync.WaitGroup
+	listener    net.Listener
+	addr        string
+	certificate []byte
+	conns       map[net.Conn]struct{}
+}
+
+// NewProxy will start a proxy listening on a loopback port that
+// forwards connections to the given address
+func NewProxy(addr string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newProxy(listener, addr, nil), nil
+}
+
+// NewTLSProxy will start a proxy listening on a loopback port that
+// terminates tls connections and forwards them to the given address;
+// it uses a self-signed certificate valid for localhost and 127.0.0.1
+func NewTLSProxy(addr string) (*Proxy, error) {
+	certificate, key, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	keyPair, err := tls.X509KeyPair(certificate, key)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{keyPair},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newProxy(listener, addr, certificate), nil
+}
+
+func newProxy(listener net.Listener, addr string, certificate []byte) *Proxy {
+	p := &Proxy{
+		listener:    listener,
+		addr:        addr,
+		certificate: certificate,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	p.Add(1)
+	go func() {
+		defer p.Done()
+
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			p.forward(c)
+		}
+	}()
+	return p
+}
+
+// Addr returns the address the proxy is listening on
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Certificate returns the (pem encoded) certificate of the proxy, it
+// can be used as a certificate authority (tls only)
+func (p *Proxy) Certificate() []byte {
+	return p.certificate
+}
+
+// Reset will close every connection (e.g. to simulate a network
+// failure), new connections are still accepted
+func (p *Proxy) Reset() {
+	p.Lock()
+	defer p.Unlock()
+
+	for c := range p.conns {
+		c.Close()
+	}
+}
+
+// Close will stop the proxy and close every connection
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+	p.Reset()
+	p.Wait()
+	return err
+}
+
+func (p *Proxy) forward(c net.Conn) {
+	upstream, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		c.Close()
+		return
+	}
+	p.Lock()
+	p.conns[c], p.conns[upstream] = struct{}{}, struct{}{}
+	p.Unlock()
+	pipe := func(dst, src net.Conn) {
+		defer p.Done()
+		defer func() {
+			p.Lock()
+			delete(p.conns, dst)
+			delete(p.conns, src)
+			p.Unlock()
+			dst.Close()
+			src.Close()
+		}()
+
+		_, _ = io.Copy(dst, src)
+	}
+	p.Add(2)
+	go pipe(upstream, c)
+	go pipe(c, upstream)
+}
+
+// selfSigned will create a (pem encoded) self-signed certificate and
+// its key
+func selfSigned() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redistest"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
@@ -0,0 +1,147 @@
+// Package redistest provides utilities to test the redis stash
+// without a redis deployment
+package redistest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	errors "github.com/pkg/errors"
+)
+
+// conn is a client connection, writes are serialized so that
+// messages can be pushed to the client (e.g. pub/sub)
+type conn struct {
+	sync.Mutex
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+		writer: bufio.NewWriter(c),
+	}
+}
+
+// readCommand will read a command, commands are sent by clients
+// as an array of bulk strings
+func (c *conn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		//KIM: inline commands are only expected when using telnet
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, errors.Errorf("invalid array length: %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errors.Errorf("expected bulk string: %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.Errorf("invalid bulk string length: %q", line)
+		}
+		bytes := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, bytes); err != nil {
+			return nil, err
+		}
+		args = append(args, string(bytes[:size]))
+	}
+	return args, nil
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// reply will write the given value and flush it to the client, the
+// type of the value determines how it's encoded:
+//
+//	error: error
+//	status: simple string
+//	string: bulk string
+//	int, int64: integer
+//	nil: null (bulk string)
+//	[]string, []any: array
+//	replies: each value is written as a separate reply
+func (c *conn) reply(value any) error {
+	c.Lock()
+	defer c.Unlock()
+
+	write(c.writer, value)
+	return c.writer.Flush()
+}
+
+// status is a simple string
+type status string
+
+// replies are written as separate replies (e.g. the confirmation
+// of each channel subscribed to)
+type replies []any
+
+func write(w *bufio.Writer, value any) {
+	switch v := value.(type) {
+	default:
+		w.WriteString("-ERR unsupported reply\r\n")
+	case nil:
+		w.WriteString("$-1\r\n")
+	case error:
+		message := v.Error()
+		if !strings.HasPrefix(message, "ERR ") && !strings.HasPrefix(message, "NOSCRIPT ") &&
+			!strings.HasPrefix(message, "WRONGTYPE ") {
+			message = "ERR " + message
+		}
+		w.WriteString("-" + strings.ReplaceAll(message, "\r\n", " ") + "\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []string:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			write(w, s)
+		}
+	case replies:
+		for _, item := range v {
+			write(w, item)
+		}
+	case []any:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			write(w, item)
+		}
+	}
+}
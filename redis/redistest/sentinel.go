@@ -0,0 +1,175 @@
+package redistest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	errors "github.com/pkg/errors"
+)
+
+// Sentinel simulates a redis sentinel that monitors a single master,
+// it can be used to test failover without running redis sentinel (or
+// more than one redis server)
+type Sentinel struct {
+	sync.RWMutex
+	sync.WaitGroup
+	listener    net.Listener
+	masterName  string
+	masterAddr  string
+	conns       map[*conn]struct{}
+	subscribers map[*conn]struct{}
+}
+
+// NewSentinel will start a sentinel listening on a loopback port that
+// reports the given address as the address of the given master
+func NewSentinel(masterName, masterAddr string) (*Sentinel, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Sentinel{
+		listener:    listener,
+		masterName:  masterName,
+		masterAddr:  masterAddr,
+		conns:       make(map[*conn]struct{}),
+		subscribers: make(map[*conn]struct{}),
+	}
+	s.Add(1)
+	go func() {
+		defer s.Done()
+
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(newConn(c))
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the sentinel is listening on
+func (s *Sentinel) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// MasterAddr returns the address of the master
+func (s *Sentinel) MasterAddr() string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.masterAddr
+}
+
+// Failover will simulate a failover by reporting the given address as
+// the address of the master and notifying subscribers (+switch-master)
+func (s *Sentinel) Failover(masterAddr string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	oldHost, oldPort, err := net.SplitHostPort(s.masterAddr)
+	if err != nil {
+		return err
+	}
+	newHost, newPort, err := net.SplitHostPort(masterAddr)
+	if err != nil {
+		return err
+	}
+	s.masterAddr = masterAddr
+	message := strings.Join([]string{s.masterName, oldHost, oldPort, newHost, newPort}, " ")
+	for c := range s.subscribers {
+		_ = c.reply([]string{"message", "+switch-master", message})
+	}
+	return nil
+}
+
+// Close will stop the sentinel and close every connection
+func (s *Sentinel) Close() error {
+	err := s.listener.Close()
+	s.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.Unlock()
+	s.Wait()
+	return err
+}
+
+func (s *Sentinel) serve(c *conn) {
+	s.Lock()
+	s.conns[c] = struct{}{}
+	s.Unlock()
+	s.Add(1)
+	go func() {
+		defer s.Done()
+		defer func() {
+			s.Lock()
+			delete(s.conns, c)
+			delete(s.subscribers, c)
+			s.Unlock()
+			c.Close()
+		}()
+
+		for {
+			args, err := c.readCommand()
+			if err != nil {
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			if err := c.reply(s.execute(c, args)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (s *Sentinel) execute(c *conn, args []string) any {
+	switch strings.ToUpper(args[0]) {
+	default:
+		return errors.Errorf("unknown command '%s'", args[0])
+	case "PING":
+		s.RLock()
+		_, subscribed := s.subscribers[c]
+		s.RUnlock()
+		if subscribed {
+			return []string{"pong", ""}
+		}
+		return status("PONG")
+	case "SUBSCRIBE":
+		if len(args) < 2 {
+			return errors.New("wrong number of arguments for 'subscribe' command")
+		}
+		//KIM: the reply of each channel is pushed, the final reply
+		// is returned
+		s.Lock()
+		s.subscribers[c] = struct{}{}
+		s.Unlock()
+		for i, channel := range args[1 : len(args)-1] {
+			_ = c.reply([]any{"subscribe", channel, i + 1})
+		}
+		return []any{"subscribe", args[len(args)-1], len(args) - 1}
+	case "SENTINEL":
+		if len(args) < 3 {
+			return errors.New("wrong number of arguments for 'sentinel' command")
+		}
+		switch strings.ToLower(args[1]) {
+		default:
+			return errors.Errorf("unknown sentinel subcommand '%s'", args[1])
+		case "get-master-addr-by-name":
+			s.RLock()
+			defer s.RUnlock()
+
+			if args[2] != s.masterName {
+				return []string(nil)
+			}
+			host, port, _ := net.SplitHostPort(s.masterAddr)
+			return []string{host, port}
+		case "sentinels", "replicas", "slaves":
+			return []string{}
+		}
+	}
+}
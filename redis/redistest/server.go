@@ -0,0 +1,326 @@
+package redistest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	errors "github.com/pkg/errors"
+)
+
+// trackingChannel is the channel invalidations are pushed to when
+// tracking is redirected
+const trackingChannel = "__redis__:invalidate"
+
+// Server is an in-process redis server, it supports the commands used
+// by the redis stash (and its scripts) so that the stash can be tested
+// without a redis deployment; data isn't persisted
+// KIM: replies are encoded using RESP2, clients (e.g. go-redis) fall
+// back to RESP2 since HELLO isn't supported
+type Server struct {
+	sync.Mutex
+	sync.WaitGroup
+	listener    net.Listener
+	databases   map[int]database
+	scripts     map[string]*script
+	clients     map[int64]*client
+	subscribers map[string]map[*client]struct{}
+	lastID      int64
+}
+
+// client is the state of a client connection
+type client struct {
+	*conn
+	id       int64
+	database int
+	channels map[string]struct{}
+	tracking *tracking
+}
+
+// tracking is the configuration of client side caching for a client,
+// only broadcasting mode is supported
+type tracking struct {
+	redirect int64
+	prefixes []string
+	noLoop   bool
+}
+
+// NewServer will start a server listening on a loopback port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener:    listener,
+		databases:   make(map[int]database),
+		scripts:     make(map[string]*script),
+		clients:     make(map[int64]*client),
+		subscribers: make(map[string]map[*client]struct{}),
+	}
+	s.Add(1)
+	go func() {
+		defer s.Done()
+
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(newConn(c))
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// FlushAll will remove every key of every database
+func (s *Server) FlushAll() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.flush(nil, -1)
+}
+
+// Close will stop the server and close every connection
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.Lock()
+	for _, c := range s.clients {
+		c.Close()
+	}
+	s.Unlock()
+	s.Wait()
+	return err
+}
+
+func (s *Server) serve(c *conn) {
+	s.Lock()
+	s.lastID++
+	client := &client{
+		conn:     c,
+		id:       s.lastID,
+		channels: make(map[string]struct{}),
+	}
+	s.clients[client.id] = client
+	s.Unlock()
+	s.Add(1)
+	go func() {
+		defer s.Done()
+		defer func() {
+			s.Lock()
+			delete(s.clients, client.id)
+			for channel := range client.channels {
+				s.unsubscribe(client, channel)
+			}
+			s.Unlock()
+			c.Close()
+		}()
+
+		for {
+			args, err := c.readCommand()
+			if err != nil {
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			if err := c.reply(s.execute(client, args)); err != nil {
+				return
+			}
+			if strings.EqualFold(args[0], "QUIT") {
+				return
+			}
+		}
+	}()
+}
+
+// execute will execute a command atomically
+func (s *Server) execute(c *client, args []string) any {
+	s.Lock()
+	defer s.Unlock()
+
+	name := strings.ToUpper(args[0])
+	if len(c.channels) > 0 {
+		switch name {
+		default:
+			return errors.Errorf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+				strings.ToLower(args[0]))
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PING", "QUIT":
+		}
+	}
+	return s.command(c, args)
+}
+
+// command will execute a command, the server must be locked
+func (s *Server) command(c *client, args []string) any {
+	cmd, ok := commands[strings.ToUpper(args[0])]
+	if !ok {
+		return errors.Errorf("unknown command '%s'", args[0])
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return errors.Errorf("wrong number of arguments for '%s' command", strings.ToLower(args[0]))
+	}
+	return cmd.fn(s, c, args)
+}
+
+// database returns the database selected by the client
+func (s *Server) database(c *client) database {
+	db, ok := s.databases[c.database]
+	if !ok {
+		db = make(database)
+		s.databases[c.database] = db
+	}
+	return db
+}
+
+// lookup returns the entry of the given key, if the key has expired it's
+// removed and not found
+func (s *Server) lookup(c *client, key string) (*entry, bool) {
+	db := s.database(c)
+	e, ok := db[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(db, key)
+		s.modified(nil, key)
+		return nil, false
+	}
+	return e, true
+}
+
+// remove will remove the given key, it returns true if the key existed
+func (s *Server) remove(c *client, key string) bool {
+	if _, ok := s.lookup(c, key); !ok {
+		return false
+	}
+	delete(s.database(c), key)
+	s.modified(c, key)
+	return true
+}
+
+// flush will remove every key of the given database (or every
+// database if negative)
+func (s *Server) flush(c *client, index int) {
+	for i, db := range s.databases {
+		if index >= 0 && i != index {
+			continue
+		}
+		for key := range db {
+			delete(db, key)
+			s.modified(c, key)
+		}
+	}
+}
+
+// modified will notify clients tracking the given key that it has been
+// modified (invalidated), the client that modified the key is used to
+// support NOLOOP
+func (s *Server) modified(by *client, key string) {
+	for _, c := range s.clients {
+		t := c.tracking
+		if t == nil || (t.noLoop && c == by) {
+			continue
+		}
+		matched := len(t.prefixes) == 0
+		for _, prefix := range t.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		redirect, ok := s.clients[t.redirect]
+		if !ok {
+			continue
+		}
+		if _, subscribed := redirect.channels[trackingChannel]; subscribed {
+			_ = redirect.reply([]any{"message", trackingChannel, []string{key}})
+		}
+	}
+}
+
+func (s *Server) subscribe(c *client, channel string) {
+	subscribers, ok := s.subscribers[channel]
+	if !ok {
+		subscribers = make(map[*client]struct{})
+		s.subscribers[channel] = subscribers
+	}
+	subscribers[c] = struct{}{}
+	c.channels[channel] = struct{}{}
+}
+
+func (s *Server) unsubscribe(c *client, channel string) {
+	delete(c.channels, channel)
+	if subscribers, ok := s.subscribers[channel]; ok {
+		delete(subscribers, c)
+		if len(subscribers) == 0 {
+			delete(s.subscribers, channel)
+		}
+	}
+}
+
+// publish will push the message to every subscriber of the channel, it
+// returns the number of subscribers
+func (s *Server) publish(channel, message string) int {
+	for c := range s.subscribers[channel] {
+		_ = c.reply([]any{"message", channel, message})
+	}
+	return len(s.subscribers[channel])
+}
+
+// clientTracking will enable (or disable) tracking for the client,
+// only broadcasting mode is supported
+func (s *Server) clientTracking(c *client, args []string) any {
+	switch strings.ToUpper(args[0]) {
+	default:
+		return errors.New("syntax error")
+	case "OFF":
+		c.tracking = nil
+		return status("OK")
+	case "ON":
+	}
+	t, bcast := &tracking{redirect: c.id}, false
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		default:
+			return errors.New("syntax error")
+		case "BCAST":
+			bcast = true
+		case "NOLOOP":
+			t.noLoop = true
+		case "REDIRECT", "PREFIX":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			if strings.EqualFold(args[i], "PREFIX") {
+				t.prefixes = append(t.prefixes, args[i+1])
+				i++
+				continue
+			}
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errors.New("value is not an integer or out of range")
+			}
+			if _, ok := s.clients[id]; !ok {
+				return errors.New("The client ID you want redirect to does not exist")
+			}
+			t.redirect = id
+			i++
+		}
+	}
+	if !bcast {
+		return errors.New("only broadcasting mode (BCAST) is supported")
+	}
+	c.tracking = t
+	return status("OK")
+}
@@ -0,0 +1,343 @@
+package redis
+
+import redis "github.com/redis/go-redis/v9"
+
+// the keys provided to every script, the indexes are sorted sets
+// whose members are the fields (keys) of the items
+// KIM: the order of these keys must match stashRedis.keys()
+//
+//	KEYS[1]: the hash that contains the items (hash storage mode)
+//	KEYS[2]: index of items by first created
+//	KEYS[3]: index of items by last read
+//	KEYS[4]: index of items by the number of times read
+//	KEYS[5]: index of items by expiry (last updated + time to live)
+//	KEYS[6]: hash of the size of each item
+//	KEYS[7]: the sum of the size of all items
+//	KEYS[8]: hash of the field and created time of the hand (second chance)
+//	KEYS[9]: hash of the read statistics of each item
+//	KEYS[10]: index of items read since the hand last passed them
+const (
+	indexCreated = "created"
+	indexRead    = "read"
+	indexReads   = "reads"
+	indexExpiry  = "expiry"
+	indexSizes   = "sizes"
+	indexBytes   = "bytes"
+	indexHand    = "hand"
+	indexStats   = "stats"
+	indexVisited = "visited"
+)
+
+// luaRemove is a lua function that will remove an item and its
+// indexes; ARGV[1] is the storage mode and ARGV[2] is the key
+// prefix (key storage mode)
+const luaRemove = `
+local function remove(field)
+	local removed
+	if ARGV[1] == 'key' then
+		removed = redis.call('DEL', ARGV[2] .. field)
+	else
+		removed = redis.call('HDEL', KEYS[1], field)
+	end
+	for i = 2, 5 do
+		redis.call('ZREM', KEYS[i], field)
+	end
+	local size = tonumber(redis.call('HGET', KEYS[6], field))
+	if size then
+		redis.call('HDEL', KEYS[6], field)
+		redis.call('DECRBY', KEYS[7], size)
+	end
+	redis.call('HDEL', KEYS[9], field .. ':last_read', field .. ':n_times_read')
+	redis.call('ZREM', KEYS[10], field)
+	return removed
+end
+`
+
+// luaItem is a set of lua functions to get, set and index an item (a
+// json encoded stash.CachedItem); ARGV[1] is the storage mode, ARGV[2]
+// is the key prefix (key storage mode)
+// KIM: the read statistics of an item are stored separately from the
+// item (and its bytes) so that reading an item doesn't rewrite it
+const luaItem = `
+local function get(field)
+	if ARGV[1] == 'key' then
+		return redis.call('GET', ARGV[2] .. field)
+	end
+	return redis.call('HGET', KEYS[1], field)
+end
+
+local function set(field, item, ttl)
+	local value = cjson.encode(item)
+	if ARGV[1] ~= 'key' then
+		redis.call('HSET', KEYS[1], field, value)
+	elseif ttl > 0 then
+		redis.call('SET', ARGV[2] .. field, value, 'PX', ttl)
+	else
+		redis.call('SET', ARGV[2] .. field, value)
+	end
+	return value
+end
+
+-- stats will read the statistics of the item, the statistics within the
+-- item are used if they haven't been stored separately
+local function stats(field, item)
+	local values = redis.call('HMGET', KEYS[9], field .. ':last_read', field .. ':n_times_read')
+	item.last_read = values[1] or item.last_read
+	item.n_times_read = tonumber(values[2]) or item.n_times_read
+end
+
+-- read will update the statistics of the item and its indexes, the
+-- last read is only updated if it's more recent and the item is marked
+-- as visited (second chance)
+local function read(field, lastRead, n)
+	local previous = redis.call('HGET', KEYS[9], field .. ':last_read')
+	if previous and tonumber(previous) > tonumber(lastRead) then
+		lastRead = previous
+	end
+	redis.call('HSET', KEYS[9], field .. ':last_read', lastRead)
+	local nTimesRead = redis.call('HINCRBY', KEYS[9], field .. ':n_times_read', n)
+	redis.call('ZADD', KEYS[3], lastRead, field)
+	redis.call('ZADD', KEYS[4], nTimesRead, field)
+	redis.call('ZADD', KEYS[10], 0, field)
+	return nTimesRead
+end
+
+local function index(field, item, expiry)
+	redis.call('ZADD', KEYS[2], item.first_created, field)
+	redis.call('ZADD', KEYS[3], item.last_read, field)
+	redis.call('ZADD', KEYS[4], item.n_times_read, field)
+	if tonumber(expiry) > 0 then
+		redis.call('ZADD', KEYS[5], expiry, field)
+	else
+		redis.call('ZREM', KEYS[5], field)
+	end
+	local previous = tonumber(redis.call('HGET', KEYS[6], field)) or 0
+	redis.call('HSET', KEYS[6], field, item.size)
+	redis.call('INCRBY', KEYS[7], item.size - previous)
+end
+`
+
+// scriptWrite will create (or update) an item and its indexes, it
+// returns whether the item was replaced and the item
+// KIM: timestamps are provided as strings since lua numbers can't
+// represent nanoseconds precisely
+//
+//	ARGV[1]: storage mode
+//	ARGV[2]: key prefix
+//	ARGV[3]: field
+//	ARGV[4]: bytes (base64 encoded)
+//	ARGV[5]: size
+//	ARGV[6]: now
+//	ARGV[7]: time to live (milliseconds)
+//	ARGV[8]: expiry (0 if the item doesn't expire)
+var scriptWrite = redis.NewScript(luaItem + `
+local field, now = ARGV[3], ARGV[6]
+local item, replaced
+local value = get(field)
+if value then
+	item, replaced = cjson.decode(value), 1
+	stats(field, item)
+else
+	item, replaced = {key = field, first_created = now, last_read = now, n_times_read = 0}, 0
+	redis.call('HDEL', KEYS[9], field .. ':last_read', field .. ':n_times_read')
+	redis.call('ZREM', KEYS[10], field)
+end
+item.bytes, item.size, item.last_updated = ARGV[4], tonumber(ARGV[5]), now
+value = set(field, item, tonumber(ARGV[7]))
+index(field, item, ARGV[8])
+return {replaced, value}
+`)
+
+// scriptRead will update the read statistics of an item and its
+// indexes, it returns the item and its read statistics (or nil if
+// not found); if the number of reads is zero, the statistics aren't
+// updated (e.g. they're buffered)
+//
+//	ARGV[1]: storage mode
+//	ARGV[2]: key prefix
+//	ARGV[3]: field
+//	ARGV[4]: now
+//	ARGV[5]: number of reads
+var scriptRead = redis.NewScript(luaItem + `
+local field, lastRead, n = ARGV[3], ARGV[4], tonumber(ARGV[5])
+local value = get(field)
+if not value then
+	return false
+end
+if n > 0 then
+	local nTimesRead = read(field, lastRead, n)
+	return {value, lastRead, nTimesRead}
+end
+local item = cjson.decode(value)
+stats(field, item)
+return {value, item.last_read, item.n_times_read}
+`)
+
+// scriptStats will update the read statistics of items and their
+// indexes if the items exist (e.g. buffered read statistics)
+//
+//	ARGV[1]: storage mode
+//	ARGV[2]: key prefix
+//	ARGV[3...]: field, last read and number of reads of each item
+var scriptStats = redis.NewScript(luaItem + `
+for i = 3, #ARGV, 3 do
+	local field = ARGV[i]
+	if redis.call('HEXISTS', KEYS[6], field) == 1 then
+		read(field, ARGV[i + 1], tonumber(ARGV[i + 2]))
+	end
+end
+return 1
+`)
+
+// scriptRemove will remove an item and its indexes, it returns the
+// number of items removed
+//
+//	ARGV[1]: storage mode
+//	ARGV[2]: key prefix
+//	ARGV[3]: field
+var scriptRemove = redis.NewScript(luaRemove + `
+return remove(ARGV[3])
+`)
+
+// scriptEvict will remove items whose time to live has been exceeded
+// and then use the index of the eviction policy to remove items until
+// the stash is no longer full; it returns whether the stash is still
+// full followed by pairs of the field and reason for each evicted item
+// KIM: if the eviction policy is empty, items aren't evicted for size
+// (e.g. a custom evictor is used)
+//
+//	ARGV[1]: storage mode
+//	ARGV[2]: key prefix
+//	ARGV[3]: eviction policy
+//	ARGV[4]: now
+//	ARGV[5]: max entries
+//	ARGV[6]: max size
+//	ARGV[7]: field to exclude
+//	ARGV[8]: max items to evict for time to live
+var scriptEvict = redis.NewScript(luaRemove + `
+local policy, now, exclude = ARGV[3], ARGV[4], ARGV[7]
+local maxEntries, maxSize = tonumber(ARGV[5]), tonumber(ARGV[6])
+local limit = 100
+local evicted = {0}
+
+local expired = redis.call('ZRANGEBYSCORE', KEYS[5], '-inf', now, 'LIMIT', 0, tonumber(ARGV[8]))
+for _, field in ipairs(expired) do
+	if field ~= exclude then
+		remove(field)
+		table.insert(evicted, field)
+		table.insert(evicted, 'ttl exceeded')
+	end
+end
+
+local function full()
+	if maxEntries > 0 and redis.call('ZCARD', KEYS[2]) > maxEntries then
+		return true
+	end
+	if maxSize > 0 and (tonumber(redis.call('GET', KEYS[7])) or 0) > maxSize then
+		return true
+	end
+	return false
+end
+
+-- first returns the first field of the index that isn't excluded
+local function first(index)
+	for _, field in ipairs(redis.call('ZRANGE', index, 0, 1)) do
+		if field ~= exclude then
+			return field
+		end
+	end
+end
+
+-- secondChance moves the hand over the index of items by first created
+-- (wrapping around to the oldest item) and returns the first field that
+-- hasn't been visited since the hand last passed it, the visited mark of
+-- every item passed is cleared; the hand is stored as the field it points
+-- to and its created time (in case the field is removed)
+-- KIM: at most limit items are passed for each victim so that redis isn't
+-- blocked if most items have been visited, if every item passed has been
+-- visited, the first item passed is returned
+local function secondChance()
+	local n = redis.call('ZCARD', KEYS[2])
+	if n == 0 then
+		return nil
+	end
+	local hand = redis.call('HMGET', KEYS[8], 'field', 'created')
+	local position = hand[1] and redis.call('ZRANK', KEYS[2], hand[1])
+	if not position then
+		position = hand[2] and redis.call('ZCOUNT', KEYS[2], '-inf', '(' .. hand[2]) or 0
+	end
+	local victim, fallback
+	for _ = 1, math.min(n, limit) do
+		local field = redis.call('ZRANGE', KEYS[2], position % n, position % n)[1]
+		position = position + 1
+		if field ~= exclude then
+			if redis.call('ZREM', KEYS[10], field) == 0 then
+				victim = field
+				break
+			end
+			fallback = fallback or field
+		end
+	end
+	victim = victim or fallback
+	local next = redis.call('ZRANGE', KEYS[2], position % n, position % n, 'WITHSCORES')
+	if next[1] and next[1] ~= victim then
+		redis.call('HSET', KEYS[8], 'field', next[1], 'created', next[2])
+	else
+		redis.call('DEL', KEYS[8])
+	end
+	return victim
+end
+
+if policy == '' then
+	if full() then
+		evicted[1] = 1
+	end
+	return evicted
+end
+while full() do
+	local victim
+	if policy == 'least_recently_used' then
+		victim = first(KEYS[3])
+	elseif policy == 'least_frequently_used' then
+		victim = first(KEYS[4])
+	elseif policy == 'sieve' or policy == 'clock' then
+		victim = secondChance()
+	else
+		victim = first(KEYS[2])
+	end
+	if not victim then
+		break
+	end
+	remove(victim)
+	table.insert(evicted, victim)
+	table.insert(evicted, 'max size exceeded')
+end
+return evicted
+`)
+
+// scriptLease will acquire (or renew) a lease if it isn't held by
+// another owner, it returns 1 if the lease is held by the owner
+//
+//	KEYS[1]: the lease
+//	ARGV[1]: owner
+//	ARGV[2]: duration (milliseconds)
+var scriptLease = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', tonumber(ARGV[2]))
+return 1
+`)
+
+// scriptRelease will release a lease if it's held by the owner, it
+// returns 1 if the lease was released
+//
+//	KEYS[1]: the lease
+//	ARGV[1]: owner
+var scriptRelease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
@@ -0,0 +1,249 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	stash "github.com/antonio-alexander/go-stash"
+	"github.com/antonio-alexander/go-stash/memory"
+
+	errors "github.com/pkg/errors"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// trackingChannel is the channel that redis publishes invalidations
+// to when tracking is redirected
+const trackingChannel = "__redis__:invalidate"
+
+// localCache is a bounded local copy of recently read items, each
+// partition has its own memory stash and epoch; the epoch is incremented
+// whenever a partition is invalidated so that an item read from redis
+// isn't stored if it was invalidated while being read
+type localCache struct {
+	sync.Mutex
+	stashes []stash.Stasher
+	epochs  []uint64
+	enabled bool
+}
+
+func newLocalCache(partitions, maxSize int) *localCache {
+	l := &localCache{
+		stashes: make([]stash.Stasher, partitions),
+		epochs:  make([]uint64, partitions),
+	}
+	for i := range l.stashes {
+		m := memory.New()
+		_ = m.Configure(memory.Configuration{
+			MaxSize:        maxSize,
+			EvictionPolicy: stash.LeastRecentlyUsed,
+		})
+		l.stashes[i] = m
+	}
+	return l
+}
+
+// read will read an item from the local cache, ok is false if the item
+// doesn't exist or the local cache is disabled
+func (l *localCache) read(partition int, field string) (*stash.CachedItem, bool) {
+	cachedItem := &stash.CachedItem{}
+
+	l.Lock()
+	enabled := l.enabled
+	l.Unlock()
+	if !enabled {
+		return nil, false
+	}
+	if err := l.stashes[partition].Read(field, cachedItem); err != nil {
+		return nil, false
+	}
+	return cachedItem, true
+}
+
+// epoch returns the epoch of the given partition
+func (l *localCache) epoch(partition int) uint64 {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.epochs[partition]
+}
+
+// store will store an item within the local cache if the partition
+// hasn't been invalidated since the given epoch
+func (l *localCache) store(partition int, epoch uint64, field string, cachedItem *stash.CachedItem) {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.enabled || l.epochs[partition] != epoch {
+		return
+	}
+	_, _ = l.stashes[partition].Write(field, cachedItem)
+}
+
+// invalidate will remove the item with the given field from the local
+// cache, if field is empty, every item of the partition is removed
+func (l *localCache) invalidate(partition int, field string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.epochs[partition]++
+	if field == "" {
+		_ = l.stashes[partition].Clear()
+		return
+	}
+	_ = l.stashes[partition].Delete(field)
+}
+
+// reset will remove every item from the local cache and enable (or
+// disable) it
+func (l *localCache) reset(enabled bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	for partition := range l.stashes {
+		l.epochs[partition]++
+		_ = l.stashes[partition].Clear()
+	}
+	l.enabled = enabled
+}
+
+// readLocal will read an item from the local cache, if the time to
+// live of the item has been exceeded, it's treated as not found
+func (s *stashRedis) readLocal(partition int, field string) (*stash.CachedItem, bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+	cachedItem, ok := s.cache.read(partition, field)
+	if !ok {
+		return nil, false
+	}
+	if timeToLive := s.config.Load().TimeToLive; timeToLive > 0 &&
+		time.Now().UnixNano() > cachedItem.LastUpdated+timeToLive.Nanoseconds() {
+		return nil, false
+	}
+	return cachedItem, true
+}
+
+// dropLocal will remove the item with the given field from the local
+// cache, it's used when the item is mutated by this process rather than
+// waiting for redis to invalidate it
+func (s *stashRedis) dropLocal(field string) {
+	if s.cache != nil {
+		s.cache.invalidate(s.partition(field), field)
+	}
+}
+
+// invalidateLocal will remove the items of the given keys (invalidated
+// by redis) from the local cache; in hash storage mode, the key is the
+// hash of a partition so every item of the partition is removed
+func (s *stashRedis) invalidateLocal(keys []string) {
+	config := s.config.Load()
+	for _, key := range keys {
+		switch config.StorageMode {
+		default:
+			for _, partition := range s.partitions() {
+				if key == s.tag(partition) {
+					s.cache.invalidate(partition, "")
+					s.printf("invalidated partition: %d\n", partition)
+				}
+			}
+		case StorageModeKey:
+			if !strings.HasPrefix(key, config.KeyPrefix+"{") {
+				continue
+			}
+			tag, field, ok := strings.Cut(strings.TrimPrefix(key, config.KeyPrefix+"{"), "}")
+			if !ok {
+				continue
+			}
+			if partition := s.partition(field); tag == s.tag(partition) {
+				s.cache.invalidate(partition, field)
+				s.printf("invalidated key: %s\n", field)
+			}
+		}
+	}
+}
+
+// launchTracking will enable client side caching, a dedicated connection
+// is subscribed to the tracking channel and tracking is enabled (in
+// broadcasting mode) with its invalidations redirected to that connection;
+// whenever the connection is (re-)established, tracking is re-enabled and
+// the local cache is cleared since invalidations may have been missed
+// KIM: tracking is per node, so client side caching isn't supported
+// when using a cluster
+func (s *stashRedis) launchTracking() error {
+	config := s.config.Load()
+	if config.LocalCacheMaxSize <= 0 {
+		return nil
+	}
+	if config.MasterName == "" && len(config.Addresses) > 0 {
+		return errors.New("client side caching isn't supported with a cluster")
+	}
+	prefix := config.HashKey
+	if config.StorageMode == StorageModeKey {
+		prefix = config.KeyPrefix
+	}
+	client, err := newClient(*config, func(ctx context.Context, cn *redis.Conn) error {
+		id, err := cn.ClientID(ctx).Result()
+		if err != nil {
+			return err
+		}
+		return cn.Process(ctx, redis.NewStatusCmd(ctx, "CLIENT", "TRACKING", "ON",
+			"REDIRECT", id, "BCAST", "PREFIX", prefix))
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	pubsub := client.Subscribe(ctx, trackingChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		client.Close()
+		return errors.Wrap(err, "unable to enable tracking")
+	}
+	s.tracking, s.trackingClient = pubsub, client
+	s.cache = newLocalCache(len(s.partitions()), s.limit(config.LocalCacheMaxSize))
+	s.cache.reset(true)
+	s.Add(1)
+	go func() {
+		defer s.Done()
+
+		for {
+			message, err := pubsub.Receive(context.Background())
+			if err != nil {
+				if err == redis.ErrClosed {
+					return
+				}
+				//KIM: invalidations may have been missed, so the local
+				// cache is cleared and only enabled if the connection
+				// can be re-established (tracking is re-enabled)
+				ctx, cancel := context.WithTimeout(context.Background(), s.config.Load().Timeout)
+				err := pubsub.Ping(ctx)
+				cancel()
+				s.cache.reset(err == nil)
+				if err != nil {
+					s.printf("error while tracking: %s\n", err)
+					select {
+					case <-s.stopper:
+						return
+					case <-time.After(100 * time.Millisecond):
+					}
+				}
+				continue
+			}
+			switch message := message.(type) {
+			case *redis.Subscription:
+				s.cache.reset(true)
+				s.printf("re-subscribed to %s, invalidated all items\n", message.Channel)
+			case *redis.Message:
+				keys := message.PayloadSlice
+				if len(keys) == 0 && message.Payload != "" {
+					keys = []string{message.Payload}
+				}
+				s.invalidateLocal(keys)
+			}
+		}
+	}()
+	return nil
+}
@@ -43,3 +43,45 @@ func (s ByLastRead) Swap(i, j int) {
 func (s ByLastRead) Less(i, j int) bool {
 	return time.Unix(0, s[i].LastRead).Before(time.Unix(0, s[j].LastRead))
 }
+
+type ByLastUpdated []*CachedItem
+
+func (s ByLastUpdated) Len() int {
+	return len(s)
+}
+
+func (s ByLastUpdated) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByLastUpdated) Less(i, j int) bool {
+	return time.Unix(0, s[i].LastUpdated).Before(time.Unix(0, s[j].LastUpdated))
+}
+
+// BySecondChance sorts items in the order they were created, but
+// items that have been read since the given time (visited) are
+// sorted after items that haven't; this approximates both the SIEVE
+// and CLOCK eviction policies
+type BySecondChance struct {
+	Items []*CachedItem
+	Since int64
+}
+
+func (s BySecondChance) visited(i int) bool {
+	return s.Items[i].NTimesRead > 0 && s.Items[i].LastRead > s.Since
+}
+
+func (s BySecondChance) Len() int {
+	return len(s.Items)
+}
+
+func (s BySecondChance) Swap(i, j int) {
+	s.Items[i], s.Items[j] = s.Items[j], s.Items[i]
+}
+
+func (s BySecondChance) Less(i, j int) bool {
+	if visitedI, visitedJ := s.visited(i), s.visited(j); visitedI != visitedJ {
+		return !visitedI
+	}
+	return s.Items[i].FirstCreated < s.Items[j].FirstCreated
+}
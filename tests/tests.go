@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"math/rand"
 	"testing"
 	"time"
@@ -142,23 +143,38 @@ func TestEvictLeastFrequentlyUsed(t *testing.T, newFx func(timeToLive time.Durat
 }) func(*testing.T) {
 	return func(t *testing.T) {
 		//generate example data
-		keys := []string{generateId(), generateId()}
-		examples := []*stash.Example{{String: generateId()}, {String: generateId()}}
+		ex := &stash.Example{String: generateId()}
+		bytes, _ := ex.MarshalBinary()
+		exampleLength := len(bytes)
+		keys := []string{generateId(), generateId(), generateId()}
+		examples := []*stash.Example{{String: generateId()}, {String: generateId()}, {String: generateId()}}
 
 		//test LeastFrequentlyUsed
-		s := newFx(time.Second, 0)
+		//KIM: an item whose time to live has been exceeded isn't
+		// readable, so the items are evicted by size
+		s := newFx(0, 2*exampleLength)
 		assert.NotNil(t, s)
 		_, err := s.Write(keys[0], examples[0])
 		assert.Nil(t, err)
 		_, err = s.Write(keys[1], examples[1])
 		assert.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			exampleRead := &stash.Example{}
+			err = s.Read(keys[0], exampleRead)
+			assert.Nil(t, err)
+		}
 		exampleRead := &stash.Example{}
-		err = s.Read(keys[0], exampleRead)
+		err = s.Read(keys[1], exampleRead)
+		assert.Nil(t, err)
+		_, err = s.Write(keys[2], examples[2])
 		assert.Nil(t, err)
-		time.Sleep(time.Second)
 		exampleRead = &stash.Example{}
 		err = s.Read(keys[0], exampleRead)
 		assert.Nil(t, err)
+		assert.Equal(t, examples[0], exampleRead)
+		exampleRead = &stash.Example{}
+		err = s.Read(keys[2], exampleRead)
+		assert.Nil(t, err)
 		exampleRead = &stash.Example{}
 		err = s.Read(keys[1], exampleRead)
 		assert.NotNil(t, err)
@@ -205,3 +221,156 @@ func TestEvictFirstInFirstOut(t *testing.T, newFx func(timeToLive time.Duration,
 		assert.NotNil(t, err)
 	}
 }
+
+//TestEvictAdaptiveReplacement can be used to validate that data that's used frequently
+// isn't evicted by a scan of data that's only used once
+func TestEvictAdaptiveReplacement(t *testing.T, newFx func(timeToLive time.Duration, maxSize int) interface {
+	stash.Stasher
+}) func(*testing.T) {
+	return func(t *testing.T) {
+		const nItems, nScan = 4, 16
+
+		//generate example data
+		ex := &stash.Example{String: generateId()}
+		bytes, _ := ex.MarshalBinary()
+		exampleLength := len(bytes)
+
+		//test AdaptiveReplacement
+		s := newFx(0, nItems*exampleLength)
+		assert.NotNil(t, s)
+		hotKeys := []string{generateId(), generateId()}
+		for _, key := range hotKeys {
+			_, err := s.Write(key, &stash.Example{String: generateId()})
+			assert.Nil(t, err)
+		}
+		for i := 0; i < 2; i++ {
+			for _, key := range hotKeys {
+				exampleRead := &stash.Example{}
+				err := s.Read(key, exampleRead)
+				assert.Nil(t, err)
+			}
+		}
+
+		//scan data that's only used once
+		for i := 0; i < nScan; i++ {
+			_, err := s.Write(generateId(), &stash.Example{String: generateId()})
+			assert.Nil(t, err)
+		}
+
+		//validate that the data used frequently wasn't evicted
+		for _, key := range hotKeys {
+			exampleRead := &stash.Example{}
+			err := s.Read(key, exampleRead)
+			assert.Nil(t, err)
+		}
+	}
+}
+
+//TestEvictSecondChance can be used to validate that data that has been read
+// (visited) isn't evicted before data that hasn't been read, even if it was
+// written first (SIEVE and CLOCK)
+func TestEvictSecondChance(t *testing.T, newFx func(timeToLive time.Duration, maxSize int) interface {
+	stash.Stasher
+}) func(*testing.T) {
+	return func(t *testing.T) {
+		//generate example data
+		ex := &stash.Example{String: generateId()}
+		bytes, _ := ex.MarshalBinary()
+		exampleLength := len(bytes)
+
+		//test eviction using a visited bit
+		s := newFx(0, 2*exampleLength)
+		assert.NotNil(t, s)
+		keys := []string{generateId(), generateId(), generateId()}
+		examples := []*stash.Example{{String: generateId()}, {String: generateId()}, {String: generateId()}}
+		_, err := s.Write(keys[0], examples[0])
+		assert.Nil(t, err)
+		_, err = s.Write(keys[1], examples[1])
+		assert.Nil(t, err)
+		exampleRead := &stash.Example{}
+		err = s.Read(keys[0], exampleRead)
+		assert.Nil(t, err)
+		_, err = s.Write(keys[2], examples[2])
+		assert.Nil(t, err)
+		exampleRead = &stash.Example{}
+		err = s.Read(keys[0], exampleRead)
+		assert.Nil(t, err)
+		assert.Equal(t, examples[0], exampleRead)
+		exampleRead = &stash.Example{}
+		err = s.Read(keys[1], exampleRead)
+		assert.NotNil(t, err)
+	}
+}
+
+//TestReconfigure validates that a stash can be reconfigured while in use, the
+// new max size should be applied immediately without losing the items that fit
+func TestReconfigure(t *testing.T, newFx func() stash.Stasher, reconfigureFx func(s stash.Stasher, maxSize int) error) func(*testing.T) {
+	return func(t *testing.T) {
+		//generate example data
+		ex := &stash.Example{String: generateId()}
+		bytes, _ := ex.MarshalBinary()
+		exampleLength := len(bytes)
+
+		//write examples without a max size
+		s := newFx()
+		assert.NotNil(t, s)
+		keys := []string{generateId(), generateId(), generateId()}
+		examples := []*stash.Example{{String: generateId()}, {String: generateId()}, {String: generateId()}}
+		for i, key := range keys {
+			_, err := s.Write(key, examples[i])
+			assert.Nil(t, err)
+			time.Sleep(time.Millisecond)
+		}
+
+		//reconfigure with a max size that only fits two examples
+		err := reconfigureFx(s, 2*exampleLength)
+		assert.Nil(t, err)
+
+		//KIM: the oldest example is evicted by the reconfiguration
+		// rather than on the next access
+		exampleRead := &stash.Example{}
+		err = s.Read(keys[0], exampleRead)
+		assert.NotNil(t, err)
+		for i, key := range keys[1:] {
+			exampleRead := &stash.Example{}
+			err = s.Read(key, exampleRead)
+			assert.Nil(t, err)
+			assert.Equal(t, examples[i+1], exampleRead)
+		}
+	}
+}
+
+//TestHealthCheck validates that an initialized stash can be pinged, that
+// its status reflects the result of the last ping and that a stash that
+// hasn't been initialized isn't usable
+func TestHealthCheck(t *testing.T, newFx func() stash.HealthChecker, newUninitializedFx func() stash.HealthChecker) func(*testing.T) {
+	return func(t *testing.T) {
+		//ping a stash that hasn't been initialized
+		s := newUninitializedFx()
+		assert.NotNil(t, s)
+		err := s.Ping(context.Background())
+		assert.NotNil(t, err)
+		assert.False(t, s.Status().Healthy())
+
+		s = newFx()
+		assert.NotNil(t, s)
+
+		//ping
+		tStart := time.Now()
+		err = s.Ping(context.Background())
+		assert.Nil(t, err)
+		status := s.Status()
+		assert.True(t, status.Healthy())
+		assert.Nil(t, status.LastError)
+		assert.False(t, status.LastPing.Before(tStart))
+
+		//ping with a cancelled context
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = s.Ping(ctx)
+		assert.NotNil(t, err)
+		status = s.Status()
+		assert.False(t, status.Healthy())
+		assert.Equal(t, err, status.LastError)
+	}
+}
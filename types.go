@@ -1,8 +1,12 @@
 package stash
 
 import (
+	"context"
 	"encoding"
 	"encoding/json"
+	"sort"
+	"strings"
+	"time"
 )
 
 // EvictionPolicy is a typed string used to describe the configured eviction
@@ -13,6 +17,9 @@ const (
 	LeastRecentlyUsed   EvictionPolicy = "least_recently_used"
 	LeastFrequentlyUsed EvictionPolicy = "least_frequently_used"
 	FirstInFirstOut     EvictionPolicy = "first_in_first_out"
+	AdaptiveReplacement EvictionPolicy = "adaptive_replacement"
+	Sieve               EvictionPolicy = "sieve"
+	Clock               EvictionPolicy = "clock"
 )
 
 // Stasher is an interface used to read and write data to a cache/stash
@@ -89,6 +96,102 @@ type Shutdowner interface {
 	Shutdown() error
 }
 
+// Pinger is an interface that can be used to check whether a stash
+// is usable (e.g. as a readiness probe)
+type Pinger interface {
+	//Ping should return an error if the stash isn't usable, for example
+	// if it hasn't been initialized or its backing store is unreachable
+	Ping(ctx context.Context) (err error)
+}
+
+// HealthChecker is an interface that can be used to check whether a
+// stash is usable and the result of the last check
+type HealthChecker interface {
+	Pinger
+
+	//Status returns the result of the last ping
+	Status() (status Status)
+}
+
+// Status describes the result of the last ping of a stash, if the
+// stash has never been pinged, LastPing will be zero
+type Status struct {
+	LastPing  time.Time
+	LastError error
+	Latency   time.Duration
+}
+
+// Healthy returns true if the stash has been pinged and the last
+// ping was successful
+func (s Status) Healthy() bool {
+	return !s.LastPing.IsZero() && s.LastError == nil
+}
+
+// Evictor is an interface that can be used to implement an eviction
+// policy; it's notified when items are written, read or deleted and
+// is asked for a victim when a stash has to evict an item. An Evictor
+// can be provided to a stash using SetParameters
+type Evictor interface {
+	//OnWrite is called when an item is created or updated
+	OnWrite(cachedItem *CachedItem)
+
+	//OnRead is called when an item is read
+	OnRead(cachedItem *CachedItem)
+
+	//OnDelete is called when an item is removed for any reason
+	// other than being chosen as a victim (e.g. deleted or expired)
+	OnDelete(key any)
+
+	//Victim should return the key of the next item to evict and stop
+	// tracking it, the exclude key should never be returned; if there's
+	// no item that can be evicted, ok should be false
+	Victim(exclude any) (key any, ok bool)
+}
+
+// EvictorFunc can be used to provide a stash with a function to create
+// an Evictor; this is required for stashes that split their data (e.g.
+// shards) since each portion needs its own Evictor
+type EvictorFunc func() Evictor
+
 type Logger interface {
 	Printf(format string, a ...any)
 }
+
+// FieldError describes why the value of a field of a configuration is
+// invalid, the field is the json tag of the field (or the name of the
+// environmental variable it was read from)
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (f FieldError) Error() string {
+	return f.Field + ": " + f.Err.Error()
+}
+
+// ConfigurationError is returned when a configuration is invalid (or
+// can't be parsed), it contains an error for each invalid field
+type ConfigurationError []FieldError
+
+func (c ConfigurationError) Error() string {
+	messages := make([]string, 0, len(c))
+	for _, fieldError := range c {
+		messages = append(messages, fieldError.Error())
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// Add will add an error for the given field
+func (c *ConfigurationError) Add(field string, err error) {
+	*c = append(*c, FieldError{Field: field, Err: err})
+}
+
+// Err returns nil if no errors have been added, otherwise it returns
+// the errors sorted by field
+func (c ConfigurationError) Err() error {
+	if len(c) == 0 {
+		return nil
+	}
+	sort.SliceStable(c, func(i, j int) bool { return c[i].Field < c[j].Field })
+	return c
+}